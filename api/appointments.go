@@ -0,0 +1,644 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"src/helpers"
+	"src/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SlotDTO struct {
+	TopicID          *int    `json:"topicId"`
+	Weekday          *int    `json:"weekday"`
+	StartTime        *string `json:"startTime"`
+	EndTime          *string `json:"endTime"`
+	Capacity         *int    `json:"capacity"`
+	BookableCapacity *int    `json:"bookableCapacity"`
+}
+
+type BookingDTO struct {
+	Slot int     `json:"slot"`
+	Date string  `json:"date"`
+	Note *string `json:"note"`
+}
+
+type SlotAvailability struct {
+	models.AppointmentSlot
+	Date      string `json:"date"`
+	Booked    int    `json:"booked"`
+	Available int    `json:"available"`
+}
+
+// parseClock reads a time of day as stored in a time(3) column.
+func parseClock(value string) (time.Duration, error) {
+	for _, layout := range []string{"15:04:05.999", "15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day '%s'", value)
+}
+
+func parseServiceDate(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", value, helpers.GetBangkokTime().Location())
+}
+
+// slotWindow returns when the slot starts and ends on the given service day.
+func slotWindow(day time.Time, startTime, endTime string) (time.Time, time.Time, error) {
+	start, err := parseClock(startTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := parseClock(endTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return day.Add(start), day.Add(end), nil
+}
+
+func validateSlot(slot models.AppointmentSlot) string {
+	if slot.Weekday < 0 || slot.Weekday > 6 {
+		return "Weekday must be between 0 (Sunday) and 6 (Saturday)"
+	}
+	start, err := parseClock(slot.StartTime)
+	if err != nil {
+		return "Invalid start time"
+	}
+	end, err := parseClock(slot.EndTime)
+	if err != nil {
+		return "Invalid end time"
+	}
+	if start >= end {
+		return "Start time must be before end time"
+	}
+	if slot.Capacity < 1 {
+		return "Capacity must be at least 1"
+	}
+	if slot.BookableCapacity < 0 || slot.BookableCapacity > slot.Capacity {
+		return "Bookable capacity must be between 0 and capacity"
+	}
+	return ""
+}
+
+func countBookings(db *gorm.DB, slotID int, date time.Time) (int, error) {
+	var count int64
+	err := db.Model(&models.Appointment{}).
+		Where("slot_id = ? AND slot_date = ? AND status IN ?", slotID, date, helpers.OPEN_APPOINTMENT_STATUSES).
+		Count(&count).Error
+	return int(count), err
+}
+
+// checkWalkInCapacity rejects a walk-in ticket when the topic has a slot
+// running now and its bookings plus the walk-ins already issued during the
+// slot fill its capacity. The slot row is locked so concurrent walk-ins are
+// counted one after another.
+func checkWalkInCapacity(tx *gorm.DB, topicID int, now time.Time) (*PolicyViolation, error) {
+	serviceDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var slot models.AppointmentSlot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("topic_id = ? AND weekday = ? AND start_time <= ? AND end_time > ?", topicID, int(now.Weekday()), now.Format("15:04:05"), now.Format("15:04:05")).
+		Order("start_time ASC").
+		Take(&slot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := slotWindow(serviceDay, slot.StartTime, slot.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	booked, err := countBookings(tx, slot.ID, serviceDay)
+	if err != nil {
+		return nil, err
+	}
+	var walkIns int64
+	err = tx.Model(&models.Queue{}).
+		Where("topic_id = ? AND appointment_id IS NULL AND created_at >= ? AND created_at < ? AND status <> ?", topicID, start, end, helpers.CANCELLED).
+		Count(&walkIns).Error
+	if err != nil {
+		return nil, err
+	}
+	if booked+int(walkIns) >= slot.Capacity {
+		return &PolicyViolation{
+			Code:    SLOT_FULL,
+			Message: fmt.Sprintf("Walk-in queues are full until %s", end.Format("15:04")),
+			RetryAt: &end,
+		}, nil
+	}
+	return nil, nil
+}
+
+func GetAppointmentSlots(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Preload("Topic").Order("topic_id ASC, weekday ASC, start_time ASC")
+		if topicID := c.Query("topic"); topicID != "" {
+			query = query.Where("topic_id = ?", topicID)
+		}
+		var slots []models.AppointmentSlot
+		if err := query.Find(&slots).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch appointment slots")
+			return
+		}
+		helpers.FormatSuccessResponse(c, slots)
+	}
+}
+
+func applySlotDTO(slot *models.AppointmentSlot, body SlotDTO) {
+	if body.TopicID != nil {
+		slot.TopicID = *body.TopicID
+	}
+	if body.Weekday != nil {
+		slot.Weekday = *body.Weekday
+	}
+	if body.StartTime != nil {
+		slot.StartTime = *body.StartTime
+	}
+	if body.EndTime != nil {
+		slot.EndTime = *body.EndTime
+	}
+	if body.Capacity != nil {
+		slot.Capacity = *body.Capacity
+	}
+	if body.BookableCapacity != nil {
+		slot.BookableCapacity = *body.BookableCapacity
+	}
+}
+
+func CreateAppointmentSlot(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body SlotDTO
+		if err := c.ShouldBindJSON(&body); err != nil || body.TopicID == nil || body.Weekday == nil || body.StartTime == nil || body.EndTime == nil || body.Capacity == nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		var slot models.AppointmentSlot
+		applySlotDTO(&slot, body)
+		if body.BookableCapacity == nil {
+			slot.BookableCapacity = slot.Capacity
+		}
+		if message := validateSlot(slot); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
+		}
+		if err := db.First(&models.Topic{}, slot.TopicID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if err := db.Create(&slot).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to create appointment slot")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "addAppointmentSlot",
+			"data":  slot,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, slot)
+	}
+}
+
+func UpdateAppointmentSlot(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var body SlotDTO
+		if err := c.ShouldBindJSON(&body); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		var slot models.AppointmentSlot
+		if err := db.First(&slot, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Appointment slot not found")
+			return
+		}
+		applySlotDTO(&slot, body)
+		if message := validateSlot(slot); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
+		}
+		if err := db.Omit("Topic").Save(&slot).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update appointment slot")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "updateAppointmentSlot",
+			"data":  slot,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, slot)
+	}
+}
+
+// DeleteAppointmentSlot stops new bookings for the slot. Appointments already
+// booked keep their own copy of the slot time and stay valid.
+func DeleteAppointmentSlot(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := db.Delete(&models.AppointmentSlot{}, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Appointment slot not found")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "deleteAppointmentSlot",
+			"data":  id,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, map[string]string{"message": "Appointment slot deleted successfully"})
+	}
+}
+
+func GetTopicSlots(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topicID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid ID format")
+			return
+		}
+		date := helpers.GetServiceDay()
+		if value := c.Query("date"); value != "" {
+			if date, err = parseServiceDate(value); err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+				return
+			}
+		}
+
+		var slots []models.AppointmentSlot
+		if err := db.Where("topic_id = ? AND weekday = ?", topicID, int(date.Weekday())).
+			Order("start_time ASC").
+			Find(&slots).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch appointment slots")
+			return
+		}
+
+		availability := []SlotAvailability{}
+		for _, slot := range slots {
+			booked, err := countBookings(db, slot.ID, date)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to count bookings")
+				return
+			}
+			availability = append(availability, SlotAvailability{
+				AppointmentSlot: slot,
+				Date:            date.Format("2006-01-02"),
+				Booked:          booked,
+				Available:       max(slot.BookableCapacity-booked, 0),
+			})
+		}
+		helpers.FormatSuccessResponse(c, availability)
+	}
+}
+
+func BookAppointment(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body BookingDTO
+		if err := c.ShouldBindJSON(&body); err != nil || body.Slot == 0 {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		date, err := parseServiceDate(body.Date)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid date, expected YYYY-MM-DD")
+			return
+		}
+
+		claims := helpers.GetClaims(c)
+		var studentID *string
+		if claims.Role == helpers.GUEST {
+			if !requireGuestLogin(c, db) {
+				return
+			}
+		} else {
+			studentID = stringPtr(claims.StudentID)
+		}
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
+
+		var slot models.AppointmentSlot
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&slot, body.Slot).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Appointment slot not found")
+			return
+		}
+		if slot.Weekday != int(date.Weekday()) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "The slot is not offered on this date")
+			return
+		}
+		start, _, err := slotWindow(date, slot.StartTime, slot.EndTime)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Invalid appointment slot time")
+			return
+		}
+		if !start.After(helpers.GetBangkokTime()) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "The slot has already started")
+			return
+		}
+
+		var existing models.Appointment
+		err = tx.Where("person_id = ? AND topic_id = ? AND slot_date = ? AND status IN ?", claims.Subject, slot.TopicID, date, helpers.OPEN_APPOINTMENT_STATUSES).
+			First(&existing).Error
+		if err == nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, map[string]interface{}{
+				"message":     "You already have an appointment for this topic on that day",
+				"appointment": existing,
+			})
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check existing appointments")
+			return
+		}
+
+		booked, err := countBookings(tx, slot.ID, date)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to count bookings")
+			return
+		}
+		if booked >= slot.BookableCapacity {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, map[string]interface{}{
+				"code":    SLOT_FULL,
+				"message": "This slot is fully booked",
+			})
+			return
+		}
+
+		appointment := models.Appointment{
+			SlotID:    slot.ID,
+			SlotDate:  date,
+			TopicID:   slot.TopicID,
+			StartTime: slot.StartTime,
+			EndTime:   slot.EndTime,
+			PersonID:  claims.Subject,
+			StudentID: studentID,
+			Firstname: claims.FirstName,
+			Lastname:  claims.LastName,
+			Note:      body.Note,
+		}
+		if err := tx.Create(&appointment).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to book appointment")
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+		if err := db.Preload("Topic").First(&appointment, appointment.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve appointment details")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "addAppointment",
+			"data":  appointment,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, appointment)
+	}
+}
+
+func GetStudentAppointments(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var appointments []models.Appointment
+		if err := db.Preload("Topic").
+			Where("person_id = ? AND slot_date >= ?", helpers.GetClaims(c).Subject, helpers.GetServiceDay()).
+			Order("slot_date ASC, start_time ASC").
+			Find(&appointments).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch appointments")
+			return
+		}
+		helpers.FormatSuccessResponse(c, appointments)
+	}
+}
+
+func findOwnAppointment(c *gin.Context, tx *gorm.DB) (models.Appointment, bool) {
+	var appointment models.Appointment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&appointment, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Appointment not found")
+			return appointment, false
+		}
+		helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve appointment")
+		return appointment, false
+	}
+	if appointment.PersonID != helpers.GetClaims(c).Subject {
+		helpers.FormatErrorResponse(c, http.StatusForbidden, "Appointment does not belong to you")
+		return appointment, false
+	}
+	if appointment.Status != helpers.APPOINTMENT_BOOKED {
+		helpers.FormatErrorResponse(c, http.StatusConflict, "Appointment is already "+string(appointment.Status))
+		return appointment, false
+	}
+	return appointment, true
+}
+
+func CancelAppointment(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tx := db.Begin()
+		appointment, ok := findOwnAppointment(c, tx)
+		if !ok {
+			tx.Rollback()
+			return
+		}
+		now := time.Now()
+		if err := tx.Model(&appointment).Updates(map[string]interface{}{
+			"status":       helpers.APPOINTMENT_CANCELLED,
+			"cancelled_at": now,
+		}).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to cancel appointment")
+			return
+		}
+		appointment.Status = helpers.APPOINTMENT_CANCELLED
+		appointment.CancelledAt = &now
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "cancelAppointment",
+			"data":  appointment,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, appointment)
+	}
+}
+
+// CheckInAppointment turns a booked appointment into a high priority ticket.
+// Check-in opens CheckInEarlyMinutes before the slot and closes when it ends.
+func CheckInAppointment(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
+
+		appointment, ok := findOwnAppointment(c, tx)
+		if !ok {
+			tx.Rollback()
+			return
+		}
+		serviceDay := helpers.GetServiceDay()
+		start, end, err := slotWindow(serviceDay, appointment.StartTime, appointment.EndTime)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Invalid appointment time")
+			return
+		}
+		now := helpers.GetBangkokTime()
+		opensAt := start.Add(-time.Duration(config.CheckInEarlyMinutes) * time.Minute)
+		if appointment.SlotDate.Format("2006-01-02") != serviceDay.Format("2006-01-02") || now.Before(opensAt) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, map[string]interface{}{
+				"message": "Check-in is not open yet",
+				"opensAt": opensAt,
+			})
+			return
+		}
+		if !now.Before(end) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, "The appointment slot has ended")
+			return
+		}
+
+		violation, err := checkReservationPolicy(tx, appointment.PersonID, appointment.TopicID, serviceDay)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check reservation policy")
+			return
+		}
+		if violation != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, violation.Response())
+			return
+		}
+
+		var topic models.Topic
+		if err := tx.First(&topic, appointment.TopicID).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve topic")
+			return
+		}
+		newQueueNo, err := allocateQueueNo(tx, topic, serviceDay)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to allocate a queue number")
+			return
+		}
+		personID := appointment.PersonID
+		code := helpers.NewTicketCode()
+		queue := models.Queue{
+			No:              newQueueNo,
+			Code:            &code,
+			ServiceDay:      &serviceDay,
+			PersonID:        &personID,
+			StudentID:       appointment.StudentID,
+			Firstname:       appointment.Firstname,
+			Lastname:        appointment.Lastname,
+			TopicID:         appointment.TopicID,
+			Note:            appointment.Note,
+			Priority:        max(helpers.PRIORITY_HIGH, topic.DefaultPriority),
+			AppointmentID:   &appointment.ID,
+			RequiresCheckIn: config.RequireCheckIn,
+		}
+		if err := tx.Create(&queue).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to create queue")
+			return
+		}
+		if err := tx.Model(&appointment).Updates(map[string]interface{}{
+			"status":        helpers.APPOINTMENT_CHECKED_IN,
+			"checked_in_at": now,
+			"queue_id":      queue.ID,
+		}).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check in appointment")
+			return
+		}
+		appointment.Status = helpers.APPOINTMENT_CHECKED_IN
+		appointment.CheckedInAt = &now
+		appointment.QueueID = &queue.ID
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue details")
+			return
+		}
+		estimate, err := EstimateQueue(db, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to estimate waiting time")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "addQueue",
+			"data": map[string]interface{}{
+				"queue":    queue,
+				"waiting":  estimate.Position,
+				"estimate": estimate,
+			},
+		})
+		hub.broadcast <- message
+		message, _ = json.Marshal(map[string]interface{}{
+			"event": "checkInAppointment",
+			"data":  appointment,
+		})
+		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"appointment": appointment,
+			"queue":       queue,
+			"waiting":     estimate.Position,
+			"estimate":    estimate,
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const archiveBatchSize = 500
+
+// queueServiceDay is the Bangkok service day of a queue. Legacy duplicates
+// have no service_day, so theirs is worked out from created_at.
+const queueServiceDay = "COALESCE(queues.service_day, DATE(queues.created_at AT TIME ZONE 'Asia/Bangkok'))"
+
+// dailyStatsSelect aggregates queues into QueueDailyStat rows, grouped by
+// service day and topic.
+func dailyStatsSelect(db *gorm.DB) *gorm.DB {
+	return db.Table("queues").Select(queueServiceDay+` AS service_day,
+		queues.topic_id AS topic_id,
+		COUNT(*) AS issued,
+		COUNT(*) FILTER (WHERE queues.status = ?) AS completed,
+		COUNT(*) FILTER (WHERE queues.status = ?) AS no_show,
+		COUNT(*) FILTER (WHERE queues.status = ?) AS skipped,
+		COUNT(*) FILTER (WHERE queues.status = ?) AS cancelled,
+		COALESCE(SUM(EXTRACT(EPOCH FROM queues.called_at - queues.created_at)), 0)::bigint AS wait_seconds,
+		COUNT(queues.called_at) AS wait_count,
+		COALESCE(SUM(EXTRACT(EPOCH FROM queues.service_ended_at - queues.service_started_at)), 0)::bigint AS service_seconds,
+		COUNT(*) FILTER (WHERE queues.service_started_at IS NOT NULL AND queues.service_ended_at IS NOT NULL) AS service_count`,
+		helpers.COMPLETED, helpers.NO_SHOW, helpers.SKIPPED, helpers.CANCELLED).
+		Where("queues.deleted_at IS NULL").
+		Group(queueServiceDay + ", queues.topic_id")
+}
+
+// ArchiveQueues moves up to archiveBatchSize queues of service days before the
+// given one into queue_archives and adds them to the daily statistics. Deleted
+// tickets are archived too but left out of the statistics. It returns
+// how many were moved, so callers repeat until it returns less than a batch.
+func ArchiveQueues(tx *gorm.DB, serviceDay time.Time) (int, error) {
+	var queueIDs []int
+	err := tx.Unscoped().Model(&models.Queue{}).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where(queueServiceDay+" < ?", serviceDay.Format("2006-01-02")).
+		Order("id").
+		Limit(archiveBatchSize).
+		Pluck("id", &queueIDs).Error
+	if err != nil || len(queueIDs) == 0 {
+		return 0, err
+	}
+
+	stats := dailyStatsSelect(tx).Where("queues.id IN ?", queueIDs)
+	err = tx.Exec(`
+		INSERT INTO queue_daily_stats (service_day, topic_id, issued, completed, no_show, skipped, cancelled,
+			wait_seconds, wait_count, service_seconds, service_count)
+		?
+		ON CONFLICT (service_day, topic_id) DO UPDATE SET
+			issued = queue_daily_stats.issued + EXCLUDED.issued,
+			completed = queue_daily_stats.completed + EXCLUDED.completed,
+			no_show = queue_daily_stats.no_show + EXCLUDED.no_show,
+			skipped = queue_daily_stats.skipped + EXCLUDED.skipped,
+			cancelled = queue_daily_stats.cancelled + EXCLUDED.cancelled,
+			wait_seconds = queue_daily_stats.wait_seconds + EXCLUDED.wait_seconds,
+			wait_count = queue_daily_stats.wait_count + EXCLUDED.wait_count,
+			service_seconds = queue_daily_stats.service_seconds + EXCLUDED.service_seconds,
+			service_count = queue_daily_stats.service_count + EXCLUDED.service_count`, stats).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Exec(`
+		INSERT INTO queue_archives (id, no, service_day, person_id, student_id, firstname, lastname, topic_id,
+			note, status, counter_id, feedback, priority, appointment_id, created_at, called_at,
+			service_started_at, service_ended_at, served_by_user_id, deleted_at, deleted_by_user_id,
+			delete_reason, archived_at)
+		SELECT id, no, service_day, person_id, student_id, firstname, lastname, topic_id,
+			note, status, counter_id, feedback, priority, appointment_id, created_at, called_at,
+			service_started_at, service_ended_at, served_by_user_id, deleted_at, deleted_by_user_id,
+			delete_reason, ?
+		FROM queues WHERE id IN ?
+		ON CONFLICT (id) DO NOTHING`, time.Now(), queueIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Unscoped().Where("id IN ?", queueIDs).Delete(&models.Queue{}).Error; err != nil {
+		return 0, err
+	}
+	return len(queueIDs), nil
+}
+
+// AnonymizePersonalData erases what identifies a customer once it is older
+// than the given time: names, student IDs and notes of archived tickets and
+// appointments, the customer's links from feedback and transitions, guest
+// sign-in codes, and finally person records nothing recent refers to. Staff
+// actors on transitions are kept. It returns how many archived tickets were
+// anonymized.
+func AnonymizePersonalData(tx *gorm.DB, before time.Time) (int64, error) {
+	expiring := tx.Model(&models.QueueArchive{}).Select("id").
+		Where("created_at < ? AND anonymized_at IS NULL", before)
+
+	err := tx.Model(&models.Feedback{}).
+		Where("(queue_id IN (?) OR created_at < ?) AND person_id IS NOT NULL", expiring, before).
+		Update("person_id", nil).Error
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Exec(`
+		UPDATE queue_transitions SET actor_person_id = NULL
+		FROM queue_archives
+		WHERE queue_archives.id = queue_transitions.queue_id
+			AND queue_archives.created_at < ? AND queue_archives.anonymized_at IS NULL
+			AND queue_transitions.actor_person_id = queue_archives.person_id`, before).Error
+	if err != nil {
+		return 0, err
+	}
+
+	result := tx.Model(&models.QueueArchive{}).
+		Where("created_at < ? AND anonymized_at IS NULL", before).
+		Updates(map[string]interface{}{
+			"person_id":     nil,
+			"student_id":    nil,
+			"firstname":     "",
+			"lastname":      "",
+			"note":          nil,
+			"delete_reason": nil,
+			"anonymized_at": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	err = tx.Model(&models.Appointment{}).
+		Where("slot_date < ? AND person_id <> ''", before).
+		Updates(map[string]interface{}{
+			"person_id":  "",
+			"student_id": nil,
+			"firstname":  "",
+			"lastname":   "",
+			"note":       nil,
+		}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Where("created_at < ?", before).Delete(&models.GuestVerification{}).Error; err != nil {
+		return 0, err
+	}
+
+	// People sign in again through an upsert, so a person record with nothing
+	// left pointing at it can simply go.
+	stale := tx.Model(&models.Person{}).Select("id").
+		Where("updated_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM queues WHERE queues.person_id = people.id)").
+		Where("NOT EXISTS (SELECT 1 FROM queue_archives WHERE queue_archives.person_id = people.id)").
+		Where("NOT EXISTS (SELECT 1 FROM appointments WHERE appointments.person_id = people.id)").
+		Where("NOT EXISTS (SELECT 1 FROM feedbacks WHERE feedbacks.person_id = people.id)")
+	if err := tx.Where("person_id IN (?)", stale).Delete(&models.Subscription{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("id IN (?)", stale).Delete(&models.Person{}).Error; err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected, nil
+}
+
+// GetQueueStats reports daily totals per topic. Archived days come from
+// queue_daily_stats and the rest is aggregated from the live queues.
+func GetQueueStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, err := parseServiceDate(c.Query("from"))
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+		to, err := parseServiceDate(c.Query("to"))
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+		if to.Before(from) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "to must not be before from")
+			return
+		}
+		first, last := from.Format("2006-01-02"), to.Format("2006-01-02")
+
+		archived := db.Model(&models.QueueDailyStat{}).Where("service_day BETWEEN ? AND ?", first, last)
+		live := dailyStatsSelect(db).Where(queueServiceDay+" BETWEEN ? AND ?", first, last)
+		if topicID := c.Query("topic"); topicID != "" {
+			archived = archived.Where("topic_id = ?", topicID)
+			live = live.Where("queues.topic_id = ?", topicID)
+		}
+
+		var stored, current []models.QueueDailyStat
+		if err := archived.Find(&stored).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve statistics")
+			return
+		}
+		if err := live.Scan(&current).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve statistics")
+			return
+		}
+
+		type statKey struct {
+			day     string
+			topicID int
+		}
+		merged := map[statKey]*models.QueueDailyStat{}
+		var keys []statKey
+		for _, stat := range append(stored, current...) {
+			key := statKey{stat.ServiceDay.Format("2006-01-02"), stat.TopicID}
+			total, ok := merged[key]
+			if !ok {
+				s := stat
+				merged[key] = &s
+				keys = append(keys, key)
+				continue
+			}
+			total.Issued += stat.Issued
+			total.Completed += stat.Completed
+			total.NoShow += stat.NoShow
+			total.Skipped += stat.Skipped
+			total.Cancelled += stat.Cancelled
+			total.WaitSeconds += stat.WaitSeconds
+			total.WaitCount += stat.WaitCount
+			total.ServiceSeconds += stat.ServiceSeconds
+			total.ServiceCount += stat.ServiceCount
+		}
+
+		stats := make([]models.QueueDailyStat, 0, len(keys))
+		for _, key := range keys {
+			stats = append(stats, *merged[key])
+		}
+		slices.SortFunc(stats, func(a, b models.QueueDailyStat) int {
+			if c := a.ServiceDay.Compare(b.ServiceDay); c != 0 {
+				return c
+			}
+			return a.TopicID - b.TopicID
+		})
+		helpers.FormatSuccessResponse(c, stats)
+	}
+}
@@ -1,176 +1,62 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 	"src/helpers"
 	"src/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"gorm.io/gorm"
 )
 
-type CMU_ENTRAID_ROLE int
-
-const (
-	MIS CMU_ENTRAID_ROLE = iota
-	STUDENT
-	ALUMNI
-	RESIGN
-	MANAGER
-	NON_MIS
-	ORG
-	PROJECT
-	RETIRED
-	VIP
-)
-
-func (r CMU_ENTRAID_ROLE) String() string {
-	return [...]string{
-		"MISEmpAcc",
-		"StdAcc",
-		"AlumAcc",
-		"EmpResiAcc",
-		"ManAcc",
-		"NonMISEmpAcc",
-		"OrgAcc",
-		"ProjAcc",
-		"RetEmpAcc",
-		"VIPAcc",
-	}[r]
-}
-
 type AuthDTO struct {
 	Code        string `json:"code" validate:"required"`
 	RedirectURI string `json:"redirectUri" validate:"required"`
 }
 
-type CmuEntraIDBasicInfoDTO struct {
-	CmuitAccountName   string `json:"cmuitaccount_name"`
-	CmuitAccount       string `json:"cmuitaccount"`
-	StudentID          string `json:"student_id"`
-	PrenameID          string `json:"prename_id"`
-	PrenameTH          string `json:"prename_TH"`
-	PrenameEN          string `json:"prename_EN"`
-	FirstnameTH        string `json:"firstname_TH"`
-	FirstnameEN        string `json:"firstname_EN"`
-	LastnameTH         string `json:"lastname_TH"`
-	LastnameEN         string `json:"lastname_EN"`
-	OrganizationCode   string `json:"organization_code"`
-	OrganizationNameTH string `json:"organization_name_TH"`
-	OrganizationNameEN string `json:"organization_name_EN"`
-	ItAccountTypeID    string `json:"itaccounttype_id"`
-	ItAccountTypeTH    string `json:"itaccounttype_TH"`
-	ItAccountTypeEN    string `json:"itaccounttype_EN"`
-}
-
-func getEntraIDAccessToken(code, redirectUri string) (string, error) {
-	data := url.Values{}
-	data.Set("code", code)
-	data.Set("redirect_uri", redirectUri)
-	data.Set("client_id", os.Getenv("CMU_ENTRAID_CLIENT_ID"))
-	data.Set("client_secret", os.Getenv("CMU_ENTRAID_CLIENT_SECRET"))
-	data.Set("scope", os.Getenv("SCOPE"))
-	data.Set("grant_type", "authorization_code")
-
-	req, err := http.NewRequest("POST", os.Getenv("CMU_ENTRAID_GET_TOKEN_URL"), bytes.NewBufferString(data.Encode()))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes := new(bytes.Buffer)
-		bodyBytes.ReadFrom(resp.Body)
-		return "", fmt.Errorf("failed to fetch access token, status: %d, body: %s", resp.StatusCode, bodyBytes.String())
-	}
-
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	token, ok := result["access_token"].(string)
-	if !ok {
-		return "", errors.New("invalid access token response")
-	}
-
-	return token, nil
-}
-
-func getCMUBasicInfo(accessToken string) (*CmuEntraIDBasicInfoDTO, error) {
-	client := &http.Client{}
-	url := os.Getenv("CMU_ENTRAID_GET_BASIC_INFO")
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to fetch CMU basic info")
-	}
-
-	var info CmuEntraIDBasicInfoDTO
-	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
-		return nil, err
-	}
-
-	return &info, nil
-}
-
-func generateJWTToken(user interface{}, notAdmin bool) (string, error) {
-	var firstName, lastName string
-	claims := jwt.MapClaims{}
+func newClaims(user interface{}, staff *models.User) helpers.Claims {
+	claims := helpers.Claims{}
 	switch v := user.(type) {
-	case CmuEntraIDBasicInfoDTO:
-		claims["email"] = v.CmuitAccount
-		firstName = v.FirstnameTH
-		lastName = v.LastnameTH
-		if v.StudentID != "" {
-			claims["studentId"] = v.StudentID
-		}
-		if notAdmin {
-			claims["role"] = helpers.STUDENT
+	case IdentityProfile:
+		claims.Email = v.Email
+		claims.FirstName = v.FirstNameTH
+		claims.LastName = v.LastNameTH
+		claims.StudentID = v.StudentID
+		if staff == nil {
+			claims.Role = helpers.STUDENT
 		} else {
-			claims["role"] = helpers.ADMIN
+			applyStaffClaims(&claims, *staff)
 		}
-		if firstName == "" {
-			firstName = helpers.Capitalize(v.FirstnameEN)
+		if claims.FirstName == "" {
+			claims.FirstName = helpers.Capitalize(v.FirstNameEN)
 		}
-		if lastName == "" {
-			lastName = helpers.Capitalize(v.LastnameEN)
+		if claims.LastName == "" {
+			claims.LastName = helpers.Capitalize(v.LastNameEN)
 		}
-		claims["faculty"] = v.OrganizationNameTH
-	case ReserveDTO:
-		firstName = *v.FirstName
-		lastName = *v.LastName
-		claims["role"] = helpers.STUDENT
+		claims.Faculty = v.Faculty
+	case models.GuestVerification:
+		claims.Email = v.Email
+		claims.FirstName = v.FirstName
+		claims.LastName = v.LastName
+		claims.Role = helpers.GUEST
 	}
-	claims["firstName"] = firstName
-	claims["lastName"] = lastName
+	return claims
+}
 
-	// expirationTime := time.Now().Add(7 * 24 * time.Hour)
-	// claims["exp"] = expirationTime.Unix()
+func applyStaffClaims(claims *helpers.Claims, user models.User) {
+	claims.Role = user.Role
+	claims.UserID = user.ID
+	claims.CounterID = user.CounterID
+}
+
+func generateJWTToken(claims helpers.Claims) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(helpers.GetEnvDuration("JWT_ACCESS_TOKEN_TTL", 15*time.Minute)))
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -183,34 +69,37 @@ func generateJWTToken(user interface{}, notAdmin bool) (string, error) {
 	return tokenString, nil
 }
 
-func Authentication(db *gorm.DB) gin.HandlerFunc {
+func Authentication(db *gorm.DB, idp IdentityProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var body AuthDTO
 		if err := c.Bind(&body); err != nil || body.Code == "" || body.RedirectURI == "" {
 			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid authorization code or redirect URI")
 			return
 		}
-		accessToken, err := getEntraIDAccessToken(body.Code, body.RedirectURI)
-		if err != nil || accessToken == "" {
-			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Cannot get EntraID access token")
-			return
-		}
-		basicInfo, err := getCMUBasicInfo(accessToken)
-		if err != nil || basicInfo == nil {
-			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Cannot get CMU basic info")
+		profile, err := idp.Authenticate(body.Code, body.RedirectURI)
+		if err != nil || profile == nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Cannot get identity profile")
 			return
 		}
 
 		var user models.User
-		result := db.Where("email = ?", basicInfo.CmuitAccount).First(&user)
+		result := db.Where("email = ?", profile.Email).First(&user)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			if basicInfo.ItAccountTypeID == STUDENT.String() {
-				tokenString, err := generateJWTToken(*basicInfo, true)
+			if profile.IsStudent {
+				claims := newClaims(*profile, nil)
+				if err := attachPerson(db, &claims); err != nil {
+					helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to save person")
+					return
+				}
+				tokenString, refreshToken, err := issueTokens(db, claims)
 				if err != nil {
 					helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate JWT token")
 					return
 				}
-				helpers.FormatSuccessResponse(c, map[string]interface{}{"token": tokenString})
+				helpers.FormatSuccessResponse(c, map[string]interface{}{
+					"token":        tokenString,
+					"refreshToken": refreshToken,
+				})
 				return
 			} else {
 				helpers.FormatErrorResponse(c, http.StatusForbidden, "Cannot access")
@@ -218,17 +107,22 @@ func Authentication(db *gorm.DB) gin.HandlerFunc {
 			}
 		}
 
-		tokenString, err := generateJWTToken(*basicInfo, false)
+		claims := newClaims(*profile, &user)
+		if err := attachPerson(db, &claims); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to save person")
+			return
+		}
+		tokenString, refreshToken, err := issueTokens(db, claims)
 		if err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate JWT token")
 			return
 		}
 
 		if user.FirstNameEN == nil || user.LastNameEN == nil {
-			user.FirstNameTH = &basicInfo.FirstnameTH
-			user.LastNameTH = &basicInfo.LastnameTH
-			user.FirstNameEN = &basicInfo.FirstnameEN
-			user.LastNameEN = &basicInfo.LastnameEN
+			user.FirstNameTH = &profile.FirstNameTH
+			user.LastNameTH = &profile.LastNameTH
+			user.FirstNameEN = &profile.FirstNameEN
+			user.LastNameEN = &profile.LastNameEN
 			if err := db.Save(&user).Error; err != nil {
 				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update user data")
 				return
@@ -236,8 +130,9 @@ func Authentication(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		helpers.FormatSuccessResponse(c, map[string]interface{}{
-			"token": tokenString,
-			"user":  user,
+			"token":        tokenString,
+			"refreshToken": refreshToken,
+			"user":         user,
 		})
 	}
 }
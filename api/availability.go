@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func validateTopicHours(topic models.Topic) string {
+	var opensAt, closesAt time.Duration
+	var err error
+	if topic.OpensAt != nil {
+		if opensAt, err = parseClock(*topic.OpensAt); err != nil {
+			return "Invalid opening time"
+		}
+	}
+	if topic.ClosesAt != nil {
+		if closesAt, err = parseClock(*topic.ClosesAt); err != nil {
+			return "Invalid closing time"
+		}
+	}
+	if topic.OpensAt != nil && topic.ClosesAt != nil && opensAt >= closesAt {
+		return "Opening time must be before closing time"
+	}
+	if topic.DailyCap < 0 {
+		return "Daily cap must not be negative"
+	}
+	return ""
+}
+
+// checkTopicAvailability tells whether the topic may issue a ticket now. It
+// returns nil when it can, or the reason it cannot. Run inside the reservation
+// transaction after locking the topic so the daily cap holds under load.
+func checkTopicAvailability(db *gorm.DB, topic models.Topic, now time.Time) (*PolicyViolation, error) {
+	serviceDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if topic.OpensAt != nil {
+		opensAt, err := parseClock(*topic.OpensAt)
+		if err != nil {
+			return nil, err
+		}
+		if now.Before(serviceDay.Add(opensAt)) {
+			return &PolicyViolation{
+				Code:    TOPIC_CLOSED,
+				Message: fmt.Sprintf("This topic opens at %s", serviceDay.Add(opensAt).Format("15:04")),
+			}, nil
+		}
+	}
+	var closesAt *time.Time
+	if topic.ClosesAt != nil {
+		offset, err := parseClock(*topic.ClosesAt)
+		if err != nil {
+			return nil, err
+		}
+		at := serviceDay.Add(offset)
+		if !now.Before(at) {
+			return &PolicyViolation{
+				Code:    TOPIC_CLOSED,
+				Message: fmt.Sprintf("This topic closed at %s", at.Format("15:04")),
+			}, nil
+		}
+		closesAt = &at
+	}
+
+	if topic.DailyCap > 0 {
+		var issued int64
+		err := db.Model(&models.Queue{}).
+			Where("topic_id = ? AND service_day = ? AND status <> ?", topic.ID, serviceDay, helpers.CANCELLED).
+			Count(&issued).Error
+		if err != nil {
+			return nil, err
+		}
+		if int(issued) >= topic.DailyCap {
+			return &PolicyViolation{
+				Code:    TOPIC_FULL,
+				Message: "This topic has reached its daily limit",
+			}, nil
+		}
+	}
+
+	if topic.StopWhenPastClosing && closesAt != nil {
+		var waiting int64
+		err := db.Model(&models.Queue{}).
+			Where("topic_id = ? AND status = ? AND held_at IS NULL", topic.ID, helpers.WAITING).
+			Count(&waiting).Error
+		if err != nil {
+			return nil, err
+		}
+		averageMinutes, err := averageServiceMinutes(db, topic.ID)
+		if err != nil {
+			return nil, err
+		}
+		openCounters, err := openCountersForTopic(db, topic.ID)
+		if err != nil {
+			return nil, err
+		}
+		estimate := newWaitEstimate(int(waiting), averageMinutes, openCounters)
+		if estimate.EstimatedMinutes == nil {
+			return &PolicyViolation{
+				Code:    TOPIC_CLOSED,
+				Message: "No counter is serving this topic right now",
+			}, nil
+		}
+		if now.Add(time.Duration(*estimate.EstimatedMinutes) * time.Minute).After(*closesAt) {
+			return &PolicyViolation{
+				Code:    TOPIC_CLOSING,
+				Message: fmt.Sprintf("The queue would not be served before closing at %s", closesAt.Format("15:04")),
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -10,15 +10,17 @@ import (
 	"gorm.io/gorm"
 )
 
+func getConfig(db *gorm.DB) (models.Config, error) {
+	var config models.Config
+	err := db.FirstOrCreate(&config, models.Config{ID: 1}).Error
+	return config, err
+}
+
 func GetConfig(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var config models.Config
-		if err := db.First(&config).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				helpers.FormatErrorResponse(c, http.StatusNotFound, "Config not found")
-			} else {
-				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
-			}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
 			return
 		}
 		helpers.FormatSuccessResponse(c, config)
@@ -35,6 +37,10 @@ func SetLoginNotCmu(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 			return
 		}
 
+		if _, err := getConfig(db); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
 		if err := db.Model(&models.Config{}).Where("id = ?", 1).Update("LoginNotCmu", body.LoginNotCmu).Error; err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update config")
 			return
@@ -49,3 +55,99 @@ func SetLoginNotCmu(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		helpers.FormatSuccessResponse(c, map[string]interface{}{"message": "Config updated successfully"})
 	}
 }
+
+func UpdateQueuePolicy(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := new(struct {
+			HoldGraceMinutes      *int  `json:"holdGraceMinutes"`
+			MaxActivePerPerson    *int  `json:"maxActivePerPerson"`
+			MaxActivePerTopic     *int  `json:"maxActivePerTopic"`
+			NoShowCooldownMinutes *int  `json:"noShowCooldownMinutes"`
+			PriorityWeight        *int  `json:"priorityWeight"`
+			MaxRecalls            *int  `json:"maxRecalls"`
+			NoShowTimeoutMinutes  *int  `json:"noShowTimeoutMinutes"`
+			CheckInEarlyMinutes   *int  `json:"checkInEarlyMinutes"`
+			RequireCheckIn        *bool `json:"requireCheckIn"`
+			DeleteUndoMinutes     *int  `json:"deleteUndoMinutes"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if body.HoldGraceMinutes != nil {
+			if *body.HoldGraceMinutes < 1 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Hold grace must be at least 1 minute")
+				return
+			}
+			updates["hold_grace_minutes"] = *body.HoldGraceMinutes
+		}
+		if body.RequireCheckIn != nil {
+			updates["require_check_in"] = *body.RequireCheckIn
+		}
+		if body.PriorityWeight != nil {
+			if *body.PriorityWeight < 1 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Priority weight must be at least 1")
+				return
+			}
+			updates["priority_weight"] = *body.PriorityWeight
+		}
+		if body.MaxRecalls != nil {
+			if *body.MaxRecalls < 1 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Max recalls must be at least 1")
+				return
+			}
+			updates["max_recalls"] = *body.MaxRecalls
+		}
+		if body.NoShowTimeoutMinutes != nil {
+			if *body.NoShowTimeoutMinutes < 1 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "No-show timeout must be at least 1 minute")
+				return
+			}
+			updates["no_show_timeout_minutes"] = *body.NoShowTimeoutMinutes
+		}
+		// Zero turns the limit or cooldown off.
+		limits := map[string]*int{
+			"max_active_per_person":    body.MaxActivePerPerson,
+			"max_active_per_topic":     body.MaxActivePerTopic,
+			"no_show_cooldown_minutes": body.NoShowCooldownMinutes,
+			"check_in_early_minutes":   body.CheckInEarlyMinutes,
+			"delete_undo_minutes":      body.DeleteUndoMinutes,
+		}
+		for column, value := range limits {
+			if value == nil {
+				continue
+			}
+			if *value < 0 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Queue limits cannot be negative")
+				return
+			}
+			updates[column] = *value
+		}
+
+		if _, err := getConfig(db); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&models.Config{}).Where("id = ?", 1).Updates(updates).Error; err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update config")
+				return
+			}
+		}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "setQueuePolicy",
+			"data":  config,
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, config)
+	}
+}
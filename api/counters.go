@@ -11,13 +11,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetCounters(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var counters []models.Counter
 		err := db.Preload("User", func(db *gorm.DB) *gorm.DB {
-			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email")
+			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email", "Role")
 		}).Preload("Topics", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).Order("counter ASC").Find(&counters).Error
 		if err != nil {
 			log.Println("Error fetching counters:", err)
@@ -27,7 +28,7 @@ func GetCounters(db *gorm.DB) gin.HandlerFunc {
 		var response []models.CounterResponse
 		for _, counter := range counters {
 			var currentQueue *models.Queue
-			err := db.Where("status = ? AND counter_id = ?", helpers.IN_PROGRESS, counter.ID).First(&currentQueue).Error
+			err := db.Where("status IN ? AND counter_id = ?", helpers.AT_COUNTER_STATUSES, counter.ID).First(&currentQueue).Error
 			if err != nil {
 				if err == gorm.ErrRecordNotFound {
 					currentQueue = nil
@@ -49,6 +50,7 @@ func GetCounters(db *gorm.DB) gin.HandlerFunc {
 					FirstNameEN: counter.User.FirstNameEN,
 					LastNameEN:  counter.User.LastNameEN,
 					Email:       counter.User.Email,
+					Role:        counter.User.Role,
 				},
 				Topics:       counter.Topics,
 				CurrentQueue: currentQueue,
@@ -115,7 +117,7 @@ func CreateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		if err == gorm.ErrRecordNotFound {
 			user = models.User{
 				Email:     body.Email,
-				CounterID: counter.ID,
+				CounterID: &counter.ID,
 			}
 			err = tx.Create(&user).Error
 			if err != nil {
@@ -124,7 +126,7 @@ func CreateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 				return
 			}
 		} else {
-			user.CounterID = counter.ID
+			user.CounterID = &counter.ID
 			err = tx.Save(&user).Error
 			if err != nil {
 				tx.Rollback()
@@ -150,7 +152,7 @@ func CreateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 
 		var result models.Counter
 		err = db.Preload("User", func(db *gorm.DB) *gorm.DB {
-			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email")
+			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email", "Role")
 		}).Preload("Topics", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).Where("id = ?", counter.ID).First(&result).Error
 		if err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch counter data")
@@ -187,6 +189,16 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 			return
 		}
 
+		claims := helpers.GetClaims(c)
+		if !canOperateCounter(claims, id) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate your own counter")
+			return
+		}
+		if claims.Role != helpers.SUPER_ADMIN && (body.Counter != nil || body.TimeClosed != nil || body.Email != nil || body.Topics != nil) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "Only super admins can change counter settings")
+			return
+		}
+
 		tx := db.Begin()
 		if tx.Error != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
@@ -224,32 +236,23 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		}
 
 		if body.Status != nil && !*body.Status {
-			var queue models.Queue
-			err = tx.Model(&models.Queue{}).
-				Where("counter_id = ? AND status = ?", counter.ID, helpers.IN_PROGRESS).
-				First(&queue).Error
-			if err != nil && err != gorm.ErrRecordNotFound {
-				tx.Rollback()
-				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
-				return
-			}
-			err = tx.Model(&models.Queue{}).
-				Where("id = ?", queue.ID).
-				Update("status", helpers.CALLED).Error
+			finished, err := FinishCounterQueues(tx, counter.ID, actorFromClaims(claims), "Counter closed")
 			if err != nil {
 				tx.Rollback()
-				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update queue status")
+				helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to update queue status")
 				return
 			}
 
-			message, _ := json.Marshal(map[string]interface{}{
-				"event": "updateQueue",
-				"data": map[string]interface{}{
-					"current": nil,
-					"called":  queue,
-				},
-			})
-			hub.broadcast <- message
+			for _, queue := range finished {
+				message, _ := json.Marshal(map[string]interface{}{
+					"event": "updateQueue",
+					"data": map[string]interface{}{
+						"current": nil,
+						"called":  queue,
+					},
+				})
+				hub.broadcast <- message
+			}
 		}
 
 		if body.Topics != nil {
@@ -283,7 +286,7 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 			if err == gorm.ErrRecordNotFound {
 				user = models.User{
 					Email:     *body.Email,
-					CounterID: counter.ID,
+					CounterID: &counter.ID,
 				}
 				err = tx.Create(&user).Error
 				if err != nil {
@@ -292,7 +295,7 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 					return
 				}
 			} else {
-				user.CounterID = counter.ID
+				user.CounterID = &counter.ID
 				err = tx.Save(&user).Error
 				if err != nil {
 					tx.Rollback()
@@ -310,7 +313,7 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 
 		var updatedCounter models.Counter
 		err = db.Preload("User", func(db *gorm.DB) *gorm.DB {
-			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email")
+			return db.Select("ID", "CounterID", "FirstNameTH", "FirstNameEN", "LastNameTH", "LastNameEN", "Email", "Role")
 		}).Preload("Topics", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).Where("id = ?", counter.ID).First(&updatedCounter).Error
 		if err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch updated counter data")
@@ -322,11 +325,105 @@ func UpdateCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 			"data":  updatedCounter,
 		})
 		hub.broadcast <- message
+		if body.Status != nil || body.Topics != nil {
+			BroadcastCounterEstimates(db, hub, counter.ID)
+		}
 
 		helpers.FormatSuccessResponse(c, updatedCounter)
 	}
 }
 
+func CallNextQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid ID format")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		if !canOperateCounter(claims, id) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate your own counter")
+			return
+		}
+		actor := actorFromClaims(claims)
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
+
+		var counter models.Counter
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&counter, id).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Counter not found")
+			return
+		}
+		if !counter.Status {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Counter is closed")
+			return
+		}
+
+		finished, err := FinishCounterQueues(tx, counter.ID, actor, "Next queue called")
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to finish current queue")
+			return
+		}
+		var called interface{}
+		if len(finished) > 0 {
+			called = finished[0].ID
+		}
+
+		next, err := selectNextQueue(tx, counter.ID)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to select next queue")
+			return
+		}
+		if next != nil {
+			if err := TransitionQueue(tx, next, helpers.CALLING, actor, "", map[string]interface{}{
+				"counter_id": counter.ID,
+			}); err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to call queue: "+err.Error())
+				return
+			}
+			if err := tx.Preload("Topic").First(next, next.ID).Error; err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch current queue")
+				return
+			}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "updateQueue",
+			"data": map[string]interface{}{
+				"current":   next,
+				"called":    called,
+				"estimates": EstimateCounterQueues(db, counter.ID),
+			},
+		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"current": next,
+			"called":  called,
+		})
+	}
+}
+
 func DeleteCounter(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+type CMU_ENTRAID_ROLE int
+
+const (
+	MIS CMU_ENTRAID_ROLE = iota
+	STUDENT
+	ALUMNI
+	RESIGN
+	MANAGER
+	NON_MIS
+	ORG
+	PROJECT
+	RETIRED
+	VIP
+)
+
+func (r CMU_ENTRAID_ROLE) String() string {
+	return [...]string{
+		"MISEmpAcc",
+		"StdAcc",
+		"AlumAcc",
+		"EmpResiAcc",
+		"ManAcc",
+		"NonMISEmpAcc",
+		"OrgAcc",
+		"ProjAcc",
+		"RetEmpAcc",
+		"VIPAcc",
+	}[r]
+}
+
+type CmuEntraIDBasicInfoDTO struct {
+	CmuitAccountName   string `json:"cmuitaccount_name"`
+	CmuitAccount       string `json:"cmuitaccount"`
+	StudentID          string `json:"student_id"`
+	PrenameID          string `json:"prename_id"`
+	PrenameTH          string `json:"prename_TH"`
+	PrenameEN          string `json:"prename_EN"`
+	FirstnameTH        string `json:"firstname_TH"`
+	FirstnameEN        string `json:"firstname_EN"`
+	LastnameTH         string `json:"lastname_TH"`
+	LastnameEN         string `json:"lastname_EN"`
+	OrganizationCode   string `json:"organization_code"`
+	OrganizationNameTH string `json:"organization_name_TH"`
+	OrganizationNameEN string `json:"organization_name_EN"`
+	ItAccountTypeID    string `json:"itaccounttype_id"`
+	ItAccountTypeTH    string `json:"itaccounttype_TH"`
+	ItAccountTypeEN    string `json:"itaccounttype_EN"`
+}
+
+type EntraIDProvider struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	TokenURL     string
+	BasicInfoURL string
+}
+
+func NewEntraIDProvider() *EntraIDProvider {
+	return &EntraIDProvider{
+		ClientID:     os.Getenv("CMU_ENTRAID_CLIENT_ID"),
+		ClientSecret: os.Getenv("CMU_ENTRAID_CLIENT_SECRET"),
+		Scope:        os.Getenv("SCOPE"),
+		TokenURL:     os.Getenv("CMU_ENTRAID_GET_TOKEN_URL"),
+		BasicInfoURL: os.Getenv("CMU_ENTRAID_GET_BASIC_INFO"),
+	}
+}
+
+func (p *EntraIDProvider) Authenticate(code, redirectURI string) (*IdentityProfile, error) {
+	accessToken, err := p.getAccessToken(code, redirectURI)
+	if err != nil {
+		return nil, err
+	}
+	if accessToken == "" {
+		return nil, errors.New("empty access token")
+	}
+	info, err := p.getBasicInfo(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IdentityProfile{
+		Email:       info.CmuitAccount,
+		StudentID:   info.StudentID,
+		FirstNameTH: info.FirstnameTH,
+		LastNameTH:  info.LastnameTH,
+		FirstNameEN: info.FirstnameEN,
+		LastNameEN:  info.LastnameEN,
+		Faculty:     info.OrganizationNameTH,
+		IsStudent:   info.ItAccountTypeID == STUDENT.String(),
+	}, nil
+}
+
+func (p *EntraIDProvider) getAccessToken(code, redirectUri string) (string, error) {
+	data := url.Values{}
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectUri)
+	data.Set("client_id", p.ClientID)
+	data.Set("client_secret", p.ClientSecret)
+	data.Set("scope", p.Scope)
+	data.Set("grant_type", "authorization_code")
+
+	req, err := http.NewRequest("POST", p.TokenURL, bytes.NewBufferString(data.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes := new(bytes.Buffer)
+		bodyBytes.ReadFrom(resp.Body)
+		return "", fmt.Errorf("failed to fetch access token, status: %d, body: %s", resp.StatusCode, bodyBytes.String())
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	token, ok := result["access_token"].(string)
+	if !ok {
+		return "", errors.New("invalid access token response")
+	}
+
+	return token, nil
+}
+
+func (p *EntraIDProvider) getBasicInfo(accessToken string) (*CmuEntraIDBasicInfoDTO, error) {
+	client := &http.Client{}
+	req, err := http.NewRequest("GET", p.BasicInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("failed to fetch CMU basic info")
+	}
+
+	var info CmuEntraIDBasicInfoDTO
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
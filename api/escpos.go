@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	escposAlignLeft   = 0
+	escposAlignCenter = 1
+)
+
+// EscPos builds a print job for ESC/POS thermal printers. Text is sent in
+// TIS-620 so Thai prints with the printer's Thai code page selected.
+type EscPos struct {
+	buf      bytes.Buffer
+	Columns  int
+	CodePage byte
+}
+
+// NewEscPos starts a job for a 58mm (32 column) or 80mm (48 column) roll.
+func NewEscPos(paperWidth int, codePage byte) *EscPos {
+	columns := 48
+	if paperWidth == 58 {
+		columns = 32
+	}
+	p := &EscPos{Columns: columns, CodePage: codePage}
+	p.buf.Write([]byte{0x1b, '@'})
+	p.buf.Write([]byte{0x1b, 't', codePage})
+	return p
+}
+
+// encodeTIS620 maps Thai runes to TIS-620 and replaces anything else outside
+// ASCII with '?'.
+func encodeTIS620(s string) []byte {
+	out := make([]byte, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r >= 0x0e01 && r <= 0x0e5b:
+			out = append(out, byte(r-0x0e00+0xa0))
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+func (p *EscPos) Align(align byte) *EscPos {
+	p.buf.Write([]byte{0x1b, 'a', align})
+	return p
+}
+
+func (p *EscPos) Bold(on bool) *EscPos {
+	var n byte
+	if on {
+		n = 1
+	}
+	p.buf.Write([]byte{0x1b, 'E', n})
+	return p
+}
+
+// Size scales characters, 1 being normal and 8 the largest.
+func (p *EscPos) Size(width, height byte) *EscPos {
+	p.buf.Write([]byte{0x1d, '!', (width-1)<<4 | (height - 1)})
+	return p
+}
+
+func (p *EscPos) Line(s string) *EscPos {
+	p.buf.Write(encodeTIS620(s))
+	p.buf.WriteByte('\n')
+	return p
+}
+
+func (p *EscPos) Rule() *EscPos {
+	return p.Line(strings.Repeat("-", p.Columns))
+}
+
+func (p *EscPos) Feed(lines byte) *EscPos {
+	p.buf.Write([]byte{0x1b, 'd', lines})
+	return p
+}
+
+// QRCode prints data as a native QR code, so no image rendering is needed.
+func (p *EscPos) QRCode(data string, moduleSize byte) *EscPos {
+	storeLen := len(data) + 3
+	p.buf.Write([]byte{0x1d, '(', 'k', 4, 0, '1', 'A', '2', 0})
+	p.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'C', moduleSize})
+	p.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'E', '1'})
+	p.buf.Write([]byte{0x1d, '(', 'k', byte(storeLen % 256), byte(storeLen / 256), '1', 'P', '0'})
+	p.buf.WriteString(data)
+	p.buf.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'Q', '0'})
+	return p
+}
+
+func (p *EscPos) Cut() *EscPos {
+	p.buf.Write([]byte{0x1d, 'V', 'B', 0})
+	return p
+}
+
+func (p *EscPos) Bytes() []byte {
+	return p.buf.Bytes()
+}
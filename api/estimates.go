@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	estimateSampleSize = 20
+	estimateLookback   = 14 * 24 * time.Hour
+)
+
+type WaitEstimate struct {
+	Position              int     `json:"position"`
+	EstimatedMinutes      *int    `json:"estimatedMinutes"`
+	AverageServiceMinutes float64 `json:"averageServiceMinutes"`
+	OpenCounters          int     `json:"openCounters"`
+}
+
+type QueueEstimate struct {
+	QueueID int    `json:"queueId"`
+	TopicID int    `json:"topicId"`
+	No      string `json:"no"`
+	WaitEstimate
+}
+
+func averageServiceMinutes(db *gorm.DB, topicID int) (float64, error) {
+	var average *float64
+	err := db.Raw(`
+		SELECT AVG(EXTRACT(EPOCH FROM service_ended_at - service_started_at)) / 60
+		FROM (
+			SELECT service_started_at, service_ended_at FROM queues
+			WHERE topic_id = ? AND status = ? AND deleted_at IS NULL AND service_started_at IS NOT NULL
+				AND service_ended_at IS NOT NULL AND service_ended_at > ?
+			ORDER BY service_ended_at DESC
+			LIMIT ?
+		) recent`, topicID, helpers.COMPLETED, time.Now().Add(-estimateLookback), estimateSampleSize).Scan(&average).Error
+	if err != nil {
+		return 0, err
+	}
+	if average == nil || *average <= 0 {
+		return float64(helpers.GetEnvInt("DEFAULT_SERVICE_MINUTES", 5)), nil
+	}
+	return *average, nil
+}
+
+func openCountersForTopic(db *gorm.DB, topicID int) (int, error) {
+	var count int64
+	err := db.Model(&models.Counter{}).
+		Joins("JOIN counter_topics ON counter_topics.counter_id = counters.id").
+		Where("counter_topics.topic_id = ? AND counters.status = ?", topicID, true).
+		Count(&count).Error
+	return int(count), err
+}
+
+func newWaitEstimate(position int, averageMinutes float64, openCounters int) WaitEstimate {
+	estimate := WaitEstimate{
+		Position:              position,
+		AverageServiceMinutes: math.Round(averageMinutes*10) / 10,
+		OpenCounters:          openCounters,
+	}
+	if openCounters > 0 {
+		minutes := int(math.Ceil(float64(position) * averageMinutes / float64(openCounters)))
+		estimate.EstimatedMinutes = &minutes
+	}
+	return estimate
+}
+
+// EstimateTopicQueues estimates the wait of every waiting ticket in the given
+// topics from recent service durations and the counters currently open.
+func EstimateTopicQueues(db *gorm.DB, topicIDs ...int) ([]QueueEstimate, error) {
+	weight, err := priorityWeight(db)
+	if err != nil {
+		return nil, err
+	}
+	estimates := []QueueEstimate{}
+	for _, topicID := range topicIDs {
+		var waitingQueues []models.Queue
+		err := db.Where("topic_id = ? AND status = ?", topicID, helpers.WAITING).
+			Order("enqueued_at ASC, id ASC").
+			Find(&waitingQueues).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(waitingQueues) == 0 {
+			continue
+		}
+		averageMinutes, err := averageServiceMinutes(db, topicID)
+		if err != nil {
+			return nil, err
+		}
+		openCounters, err := openCountersForTopic(db, topicID)
+		if err != nil {
+			return nil, err
+		}
+		// Tickets call-next passes over do not count as being ahead of anyone.
+		position := 0
+		for _, queue := range interleaveByPriority(waitingQueues, weight, 0) {
+			estimates = append(estimates, QueueEstimate{
+				QueueID:      queue.ID,
+				TopicID:      topicID,
+				No:           queue.No,
+				WaitEstimate: newWaitEstimate(position, averageMinutes, openCounters),
+			})
+			if isCallable(queue) {
+				position++
+			}
+		}
+	}
+	return estimates, nil
+}
+
+func EstimateQueue(db *gorm.DB, queue models.Queue) (WaitEstimate, error) {
+	if queue.Status != helpers.WAITING {
+		return WaitEstimate{}, nil
+	}
+	estimates, err := EstimateTopicQueues(db, queue.TopicID)
+	if err != nil {
+		return WaitEstimate{}, err
+	}
+	for _, estimate := range estimates {
+		if estimate.QueueID == queue.ID {
+			return estimate.WaitEstimate, nil
+		}
+	}
+	return WaitEstimate{}, nil
+}
+
+func counterTopicIDs(db *gorm.DB, counterID int) ([]int, error) {
+	var topicIDs []int
+	err := db.Model(&models.CounterTopic{}).Where("counter_id = ?", counterID).Pluck("topic_id", &topicIDs).Error
+	return topicIDs, err
+}
+
+// EstimateCounterQueues is used for events, where a failed estimate should
+// not hold back the update itself.
+func EstimateCounterQueues(db *gorm.DB, counterID int) []QueueEstimate {
+	topicIDs, err := counterTopicIDs(db, counterID)
+	if err != nil {
+		log.Printf("Error fetching topics of counter %d: %v", counterID, err)
+		return nil
+	}
+	estimates, err := EstimateTopicQueues(db, topicIDs...)
+	if err != nil {
+		log.Printf("Error estimating wait times for counter %d: %v", counterID, err)
+		return nil
+	}
+	return estimates
+}
+
+func BroadcastCounterEstimates(db *gorm.DB, hub *Hub, counterID int) {
+	topicIDs, err := counterTopicIDs(db, counterID)
+	if err != nil {
+		log.Printf("Error fetching topics of counter %d: %v", counterID, err)
+		return
+	}
+	BroadcastEstimates(db, hub, topicIDs...)
+}
+
+func BroadcastEstimates(db *gorm.DB, hub *Hub, topicIDs ...int) {
+	estimates, err := EstimateTopicQueues(db, topicIDs...)
+	if err != nil {
+		log.Printf("Error estimating wait times: %v", err)
+		return
+	}
+	message, _ := json.Marshal(map[string]interface{}{
+		"event": "updateEstimates",
+		"data":  estimates,
+	})
+	hub.broadcast <- message
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"src/helpers"
 	"src/models"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
@@ -12,18 +13,13 @@ import (
 
 func GetFeedbackByUser(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		claims, err := helpers.ExtractToken(c)
-		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusUnauthorized, err.Error())
-			return
-		}
-		email, ok := (*claims)["email"].(string)
-		if !ok || email == "" {
+		claims := helpers.GetClaims(c)
+		if claims.Email == "" {
 			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Email claim is missing or invalid in token")
 			return
 		}
 		var user models.User
-		err = db.Where("email = ?", email).First(&user).Error
+		err := db.Where("email = ?", claims.Email).First(&user).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
 				helpers.FormatErrorResponse(c, http.StatusNotFound, "User not found")
@@ -32,8 +28,16 @@ func GetFeedbackByUser(db *gorm.DB) gin.HandlerFunc {
 			}
 			return
 		}
+		userID := user.ID
+		if userIDQuery := c.Query("userId"); userIDQuery != "" && (claims.Role == helpers.SUPERVISOR || claims.Role == helpers.SUPER_ADMIN) {
+			userID, err = strconv.Atoi(userIDQuery)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid userId")
+				return
+			}
+		}
 		var feedback []models.Feedback
-		err = db.Where("user_id = ?", user.ID).Find(&feedback).Error
+		err = db.Where("user_id = ?", userID).Find(&feedback).Error
 		if err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve feedback")
 			return
@@ -47,6 +51,7 @@ func CreateFeedback(db *gorm.DB) gin.HandlerFunc {
 		body := new(struct {
 			UserId   int      `json:"userId"`
 			TopicId  int      `json:"topicId"`
+			QueueId  *int     `json:"queueId"`
 			Rating   int      `json:"rating"`
 			Tags     []string `json:"tags"`
 			Feedback *string  `json:"feedback"`
@@ -56,8 +61,23 @@ func CreateFeedback(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		personID := helpers.GetClaims(c).Subject
+		if body.QueueId != nil {
+			var count int64
+			if err := db.Model(&models.Queue{}).Where("id = ? AND person_id = ?", *body.QueueId, personID).Count(&count).Error; err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue")
+				return
+			}
+			if count == 0 {
+				helpers.FormatErrorResponse(c, http.StatusForbidden, "Queue does not belong to you")
+				return
+			}
+		}
+
 		feedback := models.Feedback{
 			UserID:   body.UserId,
+			PersonID: &personID,
+			QueueID:  body.QueueId,
 			TopicID:  body.TopicId,
 			Rating:   body.Rating,
 			Tags:     pq.StringArray(body.Tags),
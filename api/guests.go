@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"src/helpers"
+	"src/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	guestCodeMaxAttempts = 5
+	guestCodeResendDelay = time.Minute
+)
+
+func hashGuestCode(email, code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email) + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateGuestCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func requireGuestLogin(c *gin.Context, db *gorm.DB) bool {
+	config, err := getConfig(db)
+	if err != nil {
+		helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+		return false
+	}
+	if !config.LoginNotCmu {
+		helpers.FormatErrorResponse(c, http.StatusForbidden, "Guest sign-in is disabled")
+		return false
+	}
+	return true
+}
+
+func RequestGuestCode(db *gorm.DB, mailer MailSender) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := new(struct {
+			Email     string `json:"email"`
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.FirstName == "" || body.LastName == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		address, err := mail.ParseAddress(body.Email)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid email")
+			return
+		}
+		email := strings.ToLower(address.Address)
+
+		if !requireGuestLogin(c, db) {
+			return
+		}
+
+		var recent int64
+		err = db.Model(&models.GuestVerification{}).
+			Where("email = ? AND created_at > ?", email, time.Now().Add(-guestCodeResendDelay)).
+			Count(&recent).Error
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check verification codes")
+			return
+		}
+		if recent > 0 {
+			helpers.FormatErrorResponse(c, http.StatusTooManyRequests, "Please wait before requesting another code")
+			return
+		}
+
+		code, err := generateGuestCode()
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate verification code")
+			return
+		}
+		ttl := helpers.GetEnvDuration("GUEST_CODE_TTL", 10*time.Minute)
+		verification := models.GuestVerification{
+			Email:     email,
+			FirstName: body.FirstName,
+			LastName:  body.LastName,
+			CodeHash:  hashGuestCode(email, code),
+			ExpiresAt: time.Now().Add(ttl),
+		}
+		if err := db.Create(&verification).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to save verification code")
+			return
+		}
+
+		message := fmt.Sprintf("Your verification code is %s\n\nThis code expires in %d minutes.", code, int(ttl.Minutes()))
+		if err := mailer.Send(email, "Queue verification code", message); err != nil {
+			log.Printf("Error sending verification code to %s: %v", email, err)
+			helpers.FormatErrorResponse(c, http.StatusBadGateway, "Failed to send verification code")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"message":   "Verification code sent",
+			"expiresAt": verification.ExpiresAt,
+		})
+	}
+}
+
+func VerifyGuestCode(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := new(struct {
+			Email string `json:"email"`
+			Code  string `json:"code"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.Email == "" || body.Code == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		email := strings.ToLower(strings.TrimSpace(body.Email))
+
+		if !requireGuestLogin(c, db) {
+			return
+		}
+
+		var verification models.GuestVerification
+		err := db.Where("email = ? AND verified_at IS NULL AND expires_at > ?", email, time.Now()).
+			Order("created_at DESC").
+			First(&verification).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Verification code is invalid or has expired")
+				return
+			}
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve verification code")
+			return
+		}
+		// Use up an attempt before comparing, so concurrent guesses cannot get
+		// past the limit.
+		result := db.Model(&models.GuestVerification{}).
+			Where("id = ? AND attempts < ? AND verified_at IS NULL", verification.ID, guestCodeMaxAttempts).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if result.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusTooManyRequests, "Too many attempts, please request a new code")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hashGuestCode(email, body.Code)), []byte(verification.CodeHash)) != 1 {
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Verification code is invalid or has expired")
+			return
+		}
+
+		result = db.Model(&models.GuestVerification{}).
+			Where("id = ? AND verified_at IS NULL", verification.ID).
+			Update("verified_at", time.Now())
+		if result.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to verify code")
+			return
+		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Verification code is invalid or has expired")
+			return
+		}
+
+		claims := newClaims(verification, nil)
+		if err := attachPerson(db, &claims); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to save person")
+			return
+		}
+		tokenString, refreshToken, err := issueTokens(db, claims)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate JWT token")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"token":        tokenString,
+			"refreshToken": refreshToken,
+		})
+	}
+}
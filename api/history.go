@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	historyDefaultLimit = 20
+	historyMaxLimit     = 100
+)
+
+var historySortColumns = map[string]string{
+	"createdAt": "queues.created_at",
+	"no":        "queues.no",
+}
+
+// historyColumns are the columns queues and queue_archives share, so the two
+// can be searched as one table.
+const historyColumns = `id, no, service_day, person_id, student_id, firstname, lastname, topic_id, note,
+	status, counter_id, feedback, priority, appointment_id, created_at, called_at, service_started_at,
+	service_ended_at, served_by_user_id, deleted_at, deleted_by_user_id, delete_reason`
+
+type QueueHistoryItem struct {
+	models.Queue
+	Archived        bool              `json:"archived"`
+	Counter         *models.Counter   `json:"counter"`
+	FeedbackDetails []models.Feedback `json:"feedbackDetails"`
+}
+
+// historyCursor is the sort value and id of the last row of a page. It is
+// handed out base64 encoded so clients treat it as opaque.
+type historyCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeHistoryCursor(cursor historyCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeHistoryCursor(value string) (historyCursor, error) {
+	var cursor historyCursor
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
+
+func historySortValue(queue models.Queue, sort string) string {
+	if sort == "no" {
+		return queue.No
+	}
+	return queue.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// SearchQueueHistory looks up past tickets for staff, archived and deleted
+// ones included unless filtered out. Dates are Bangkok service days and both
+// ends are inclusive.
+func SearchQueueHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var source *gorm.DB
+		switch c.DefaultQuery("archived", "include") {
+		case "include":
+			source = db.Raw("SELECT " + historyColumns + " FROM queues UNION ALL SELECT " + historyColumns + " FROM queue_archives")
+		case "only":
+			source = db.Raw("SELECT " + historyColumns + " FROM queue_archives")
+		case "exclude":
+			source = db.Raw("SELECT " + historyColumns + " FROM queues")
+		default:
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid archived, expected include, only or exclude")
+			return
+		}
+		query := db.Unscoped().Table("(?) AS queues", source).Preload("Topic")
+
+		if from := c.Query("from"); from != "" {
+			day, err := parseServiceDate(from)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+				return
+			}
+			query = query.Where("queues.created_at >= ?", day)
+		}
+		if to := c.Query("to"); to != "" {
+			day, err := parseServiceDate(to)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+				return
+			}
+			query = query.Where("queues.created_at < ?", day.AddDate(0, 0, 1))
+		}
+		if topicID := c.Query("topic"); topicID != "" {
+			query = query.Where("queues.topic_id = ?", topicID)
+		}
+		if counterID := c.Query("counter"); counterID != "" {
+			query = query.Where("queues.counter_id = ?", counterID)
+		}
+		if status := c.Query("status"); status != "" {
+			var statuses []helpers.STATUS
+			for _, value := range strings.Split(status, ",") {
+				s := helpers.STATUS(strings.ToUpper(strings.TrimSpace(value)))
+				if !slices.Contains(helpers.ALL_STATUSES, s) {
+					helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid status '"+value+"'")
+					return
+				}
+				statuses = append(statuses, s)
+			}
+			query = query.Where("queues.status IN ?", statuses)
+		}
+		switch c.DefaultQuery("deleted", "include") {
+		case "include":
+		case "only":
+			query = query.Where("queues.deleted_at IS NOT NULL")
+		case "exclude":
+			query = query.Where("queues.deleted_at IS NULL")
+		default:
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid deleted, expected include, only or exclude")
+			return
+		}
+		if studentID := c.Query("studentId"); studentID != "" {
+			query = query.Where("queues.student_id = ?", studentID)
+		}
+		if name := strings.TrimSpace(c.Query("name")); name != "" {
+			pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name) + "%"
+			query = query.Where("(queues.firstname || ' ' || queues.lastname) ILIKE ?", pattern)
+		}
+
+		sort := c.DefaultQuery("sort", "createdAt")
+		column, ok := historySortColumns[sort]
+		if !ok {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid sort, expected createdAt or no")
+			return
+		}
+		order := strings.ToLower(c.DefaultQuery("order", "desc"))
+		if order != "asc" && order != "desc" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid order, expected asc or desc")
+			return
+		}
+		limit := historyDefaultLimit
+		if value := c.Query("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = min(parsed, historyMaxLimit)
+		}
+
+		if value := c.Query("cursor"); value != "" {
+			cursor, err := decodeHistoryCursor(value)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+			var sortValue interface{} = cursor.Value
+			if sort == "createdAt" {
+				createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+				if err != nil {
+					helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid cursor")
+					return
+				}
+				sortValue = createdAt
+			}
+			comparison := "<"
+			if order == "asc" {
+				comparison = ">"
+			}
+			query = query.Where("("+column+", queues.id) "+comparison+" (?, ?)", sortValue, cursor.ID)
+		}
+
+		var queues []models.Queue
+		if err := query.Order(column + " " + order).Order("queues.id " + order).
+			Limit(limit + 1).
+			Find(&queues).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to search queues")
+			return
+		}
+
+		var nextCursor *string
+		if len(queues) > limit {
+			queues = queues[:limit]
+			last := queues[len(queues)-1]
+			cursor := encodeHistoryCursor(historyCursor{Value: historySortValue(last, sort), ID: last.ID})
+			nextCursor = &cursor
+		}
+
+		items, err := withHistoryDetails(db, queues)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue details")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"items":      items,
+			"nextCursor": nextCursor,
+		})
+	}
+}
+
+// withHistoryDetails attaches the counter and feedback of each queue. They are
+// looked up separately because queues do not reference them by foreign key.
+func withHistoryDetails(db *gorm.DB, queues []models.Queue) ([]QueueHistoryItem, error) {
+	var queueIDs, counterIDs []int
+	for _, queue := range queues {
+		queueIDs = append(queueIDs, queue.ID)
+		if queue.CounterID != nil && !slices.Contains(counterIDs, *queue.CounterID) {
+			counterIDs = append(counterIDs, *queue.CounterID)
+		}
+	}
+
+	// Rows come back with only the columns archives keep, so tickets still in
+	// the queues table are reloaded whole.
+	live := map[int]models.Queue{}
+	if len(queueIDs) > 0 {
+		var found []models.Queue
+		if err := db.Unscoped().Preload("Topic").Where("id IN ?", queueIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, queue := range found {
+			live[queue.ID] = queue
+		}
+	}
+
+	counters := map[int]models.Counter{}
+	if len(counterIDs) > 0 {
+		var found []models.Counter
+		if err := db.Where("id IN ?", counterIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, counter := range found {
+			counters[counter.ID] = counter
+		}
+	}
+
+	feedback := map[int][]models.Feedback{}
+	if len(queueIDs) > 0 {
+		var found []models.Feedback
+		if err := db.Preload("User").Where("queue_id IN ?", queueIDs).Order("created_at ASC").Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			feedback[*f.QueueID] = append(feedback[*f.QueueID], f)
+		}
+	}
+
+	items := make([]QueueHistoryItem, 0, len(queues))
+	for _, queue := range queues {
+		item := QueueHistoryItem{Queue: queue, Archived: true, FeedbackDetails: feedback[queue.ID]}
+		if full, ok := live[queue.ID]; ok {
+			item.Queue = full
+			item.Archived = false
+		}
+		if queue.CounterID != nil {
+			if counter, ok := counters[*queue.CounterID]; ok {
+				item.Counter = &counter
+			}
+		}
+		if item.FeedbackDetails == nil {
+			item.FeedbackDetails = []models.Feedback{}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
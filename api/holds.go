@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func holdExpiresAt(queue models.Queue, config models.Config) *time.Time {
+	if queue.HeldAt == nil {
+		return nil
+	}
+	expiresAt := queue.HeldAt.Add(time.Duration(config.HoldGraceMinutes) * time.Minute)
+	return &expiresAt
+}
+
+func broadcastHold(db *gorm.DB, hub *Hub, event string, queue models.Queue, config models.Config) {
+	message, _ := json.Marshal(map[string]interface{}{
+		"event": event,
+		"data": map[string]interface{}{
+			"queue":         queue,
+			"holdExpiresAt": holdExpiresAt(queue, config),
+		},
+	})
+	hub.broadcast <- message
+	BroadcastEstimates(db, hub, queue.TopicID)
+}
+
+// HoldQueue lets a student step away without losing their place. Held
+// tickets stay WAITING but are passed over by call-next until they resume;
+// the scheduler skips them once the grace window runs out.
+func HoldQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findOwnQueue(c, db)
+		if !ok {
+			return
+		}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+
+		now := time.Now()
+		result := db.Model(&models.Queue{}).
+			Where("id = ? AND status = ? AND held_at IS NULL", queue.ID, helpers.WAITING).
+			Update("held_at", now)
+		if result.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to hold queue")
+			return
+		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Only a waiting queue that is not on hold can be held")
+			return
+		}
+		if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+
+		broadcastHold(db, hub, "holdQueue", queue, config)
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"queue":         queue,
+			"holdExpiresAt": holdExpiresAt(queue, config),
+		})
+	}
+}
+
+func ResumeQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findOwnQueue(c, db)
+		if !ok {
+			return
+		}
+		if queue.Status != helpers.WAITING || queue.HeldAt == nil {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Queue is not on hold")
+			return
+		}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+		if time.Now().After(*holdExpiresAt(queue, config)) {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Hold has expired, please ask staff to return your queue")
+			return
+		}
+
+		result := db.Model(&models.Queue{}).
+			Where("id = ? AND status = ? AND held_at = ?", queue.ID, helpers.WAITING, queue.HeldAt).
+			Update("held_at", nil)
+		if result.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to resume queue")
+			return
+		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Queue was changed by someone else")
+			return
+		}
+		if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+
+		broadcastHold(db, hub, "resumeQueue", queue, config)
+
+		helpers.FormatSuccessResponse(c, queue)
+	}
+}
+
+// ExpireHeldQueues skips every ticket whose hold outlived the grace window and
+// returns them.
+func ExpireHeldQueues(tx *gorm.DB) ([]models.Queue, error) {
+	config, err := getConfig(tx)
+	if err != nil {
+		return nil, err
+	}
+	threshold := time.Now().Add(-time.Duration(config.HoldGraceMinutes) * time.Minute)
+
+	var queues []models.Queue
+	err = tx.Where("status = ? AND held_at < ?", helpers.WAITING, threshold).Find(&queues).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range queues {
+		err := TransitionQueue(tx, &queues[i], helpers.SKIPPED, SystemActor, "Hold expired", map[string]interface{}{
+			"held_at": nil,
+		})
+		if err != nil {
+			return nil, err
+		}
+		queues[i].HeldAt = nil
+	}
+	return queues, nil
+}
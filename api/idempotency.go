@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxIdempotencyKeyLength = 100
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+
+func hashIdempotentRequest(body interface{}) string {
+	raw, _ := json.Marshal(body)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey reserves the key for this request inside tx. A request
+// racing on the same key blocks on the insert until the first one commits or
+// rolls back. It returns the stored record when the key was already used for
+// the same request, in which case its response should be replayed.
+func claimIdempotencyKey(tx *gorm.DB, personID, key, requestHash string) (*models.IdempotencyKey, error) {
+	now := time.Now()
+	err := tx.Where("person_id = ? AND key = ? AND expires_at < ?", personID, key, now).
+		Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.IdempotencyKey{
+		PersonID:    personID,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(helpers.GetEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)),
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := tx.Where("person_id = ? AND key = ?", personID, key).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return &existing, nil
+}
+
+// saveIdempotentResponse stores the response body for the claimed key. It
+// runs in the same transaction as the work, so a failed request leaves no
+// key behind and can be retried.
+func saveIdempotentResponse(tx *gorm.DB, personID, key string, queueID int, statusCode int, response interface{}) error {
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.IdempotencyKey{}).
+		Where("person_id = ? AND key = ?", personID, key).
+		Updates(map[string]interface{}{
+			"queue_id":    queueID,
+			"status_code": statusCode,
+			"response":    string(raw),
+		}).Error
+}
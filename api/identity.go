@@ -0,0 +1,31 @@
+package api
+
+import (
+	"fmt"
+)
+
+type IdentityProfile struct {
+	Email       string
+	StudentID   string
+	FirstNameTH string
+	LastNameTH  string
+	FirstNameEN string
+	LastNameEN  string
+	Faculty     string
+	IsStudent   bool
+}
+
+type IdentityProvider interface {
+	Authenticate(code, redirectURI string) (*IdentityProfile, error)
+}
+
+func NewIdentityProvider(name string) (IdentityProvider, error) {
+	switch name {
+	case "", "entraid":
+		return NewEntraIDProvider(), nil
+	case "mock":
+		return NewMockIdentityProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown identity provider %q", name)
+	}
+}
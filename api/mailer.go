@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+type MailSender interface {
+	Send(to, subject, body string) error
+}
+
+func NewMailSender(name string) (MailSender, error) {
+	switch name {
+	case "", "smtp":
+		return NewSMTPMailSender(), nil
+	case "log":
+		return LogMailSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail sender %q", name)
+	}
+}
+
+type SMTPMailSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailSender() *SMTPMailSender {
+	return &SMTPMailSender{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+func (s *SMTPMailSender) Send(to, subject, body string) error {
+	// Local catchers such as Mailpit accept unauthenticated mail.
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{to}, []byte(message))
+}
+
+type LogMailSender struct{}
+
+func (LogMailSender) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"slices"
+	"src/helpers"
+	"src/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func AuthMiddleware(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := helpers.ExtractToken(c)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+		if (claims.Role == helpers.STUDENT || claims.Role == helpers.GUEST) && claims.Subject == "" {
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Token has no person identity, please sign in again")
+			c.Abort()
+			return
+		}
+		if len(roles) > 0 && !slices.Contains(roles, claims.Role) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "Insufficient permissions")
+			c.Abort()
+			return
+		}
+		c.Set(helpers.CLAIMS_KEY, claims)
+		c.Next()
+	}
+}
+
+// KioskOrAuthMiddleware lets a kiosk through with the shared X-Kiosk-Key and
+// everyone else through AuthMiddleware with the given roles.
+func KioskOrAuthMiddleware(roles ...string) gin.HandlerFunc {
+	auth := AuthMiddleware(roles...)
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-Kiosk-Key")
+		if key == "" {
+			auth(c)
+			return
+		}
+		expected := os.Getenv("KIOSK_API_KEY")
+		if expected == "" || subtle.ConstantTimeCompare([]byte(key), []byte(expected)) != 1 {
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Invalid kiosk key")
+			c.Abort()
+			return
+		}
+		c.Set(helpers.CLAIMS_KEY, &helpers.Claims{Role: helpers.KIOSK})
+		c.Next()
+	}
+}
+
+func canOperateQueue(db *gorm.DB, claims *helpers.Claims, queue models.Queue) (bool, error) {
+	if claims.Role == helpers.SUPER_ADMIN {
+		return true, nil
+	}
+	if claims.Role != helpers.COUNTER_STAFF || claims.CounterID == nil {
+		return false, nil
+	}
+	if queue.CounterID != nil {
+		return *queue.CounterID == *claims.CounterID, nil
+	}
+	var count int64
+	err := db.Model(&models.CounterTopic{}).
+		Where("counter_id = ? AND topic_id = ?", *claims.CounterID, queue.TopicID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func canOperateCounter(claims *helpers.Claims, counterID int) bool {
+	if claims.Role == helpers.SUPER_ADMIN {
+		return true
+	}
+	return claims.Role == helpers.COUNTER_STAFF && claims.CounterID != nil && *claims.CounterID == counterID
+}
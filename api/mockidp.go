@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"src/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MockIdentityProvider stands in for Entra ID during development and tests.
+// The authorization code is simply the key of one of its fixed users.
+type MockIdentityProvider struct {
+	Users map[string]IdentityProfile
+}
+
+func NewMockIdentityProvider() *MockIdentityProvider {
+	staffEmail := os.Getenv("MOCK_IDP_STAFF_EMAIL")
+	if staffEmail == "" {
+		staffEmail = "staff@cmu.ac.th"
+	}
+	return &MockIdentityProvider{
+		Users: map[string]IdentityProfile{
+			"student": {
+				Email:       "student@cmu.ac.th",
+				StudentID:   "650610000",
+				FirstNameTH: "นักศึกษา",
+				LastNameTH:  "ทดสอบ",
+				FirstNameEN: "Student",
+				LastNameEN:  "Test",
+				Faculty:     "คณะวิศวกรรมศาสตร์",
+				IsStudent:   true,
+			},
+			"staff": {
+				Email:       staffEmail,
+				FirstNameTH: "เจ้าหน้าที่",
+				LastNameTH:  "ทดสอบ",
+				FirstNameEN: "Staff",
+				LastNameEN:  "Test",
+				Faculty:     "คณะวิศวกรรมศาสตร์",
+			},
+			"non-cmu": {
+				Email:       "guest@example.com",
+				FirstNameEN: "Guest",
+				LastNameEN:  "Test",
+			},
+		},
+	}
+}
+
+func (p *MockIdentityProvider) Authenticate(code, redirectURI string) (*IdentityProfile, error) {
+	profile, ok := p.Users[code]
+	if !ok {
+		return nil, fmt.Errorf("unknown mock user %q", code)
+	}
+	return &profile, nil
+}
+
+func MockAuthorize(p *MockIdentityProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.DefaultQuery("user", "student")
+		if _, ok := p.Users[user]; !ok {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Unknown mock user '%v'", user))
+			return
+		}
+		redirectURI, err := url.Parse(c.Query("redirect_uri"))
+		if err != nil || redirectURI.String() == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid redirect URI")
+			return
+		}
+
+		query := redirectURI.Query()
+		query.Set("code", user)
+		if state := c.Query("state"); state != "" {
+			query.Set("state", state)
+		}
+		redirectURI.RawQuery = query.Encode()
+		c.Redirect(http.StatusFound, redirectURI.String())
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"src/helpers"
+	"src/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func stringPtr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// attachPerson records who the claims belong to in the persons table and
+// stores the stable person ID as the token subject.
+func attachPerson(db *gorm.DB, claims *helpers.Claims) error {
+	person := models.Person{
+		Kind:      helpers.PERSON_CMU,
+		Email:     stringPtr(claims.Email),
+		StudentID: stringPtr(claims.StudentID),
+		FirstName: claims.FirstName,
+		LastName:  claims.LastName,
+	}
+
+	switch {
+	case claims.Role == helpers.GUEST:
+		var existing models.Person
+		err := db.Where("kind = ? AND email = ?", helpers.PERSON_GUEST, claims.Email).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			person.ID = existing.ID
+		} else {
+			person.ID = helpers.NewUUID()
+		}
+		person.Kind = helpers.PERSON_GUEST
+	case claims.StudentID != "":
+		person.ID = claims.StudentID
+	default:
+		person.ID = claims.Email
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "student_id", "first_name", "last_name", "updated_at"}),
+	}).Create(&person).Error
+	if err != nil {
+		return err
+	}
+
+	claims.Subject = person.ID
+	return nil
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ACTIVE_TICKET_FOR_TOPIC = "ACTIVE_TICKET_FOR_TOPIC"
+	TOO_MANY_ACTIVE_TICKETS = "TOO_MANY_ACTIVE_TICKETS"
+	NO_SHOW_COOLDOWN        = "NO_SHOW_COOLDOWN"
+	SLOT_FULL               = "SLOT_FULL"
+	TOPIC_CLOSED            = "TOPIC_CLOSED"
+	TOPIC_FULL              = "TOPIC_FULL"
+	TOPIC_CLOSING           = "TOPIC_CLOSING"
+)
+
+type PolicyViolation struct {
+	Code    string
+	Message string
+	Queue   *models.Queue
+	RetryAt *time.Time
+}
+
+func (v *PolicyViolation) Response() map[string]interface{} {
+	response := map[string]interface{}{
+		"code":    v.Code,
+		"message": v.Message,
+	}
+	if v.Queue != nil {
+		response["queue"] = v.Queue
+	}
+	if v.RetryAt != nil {
+		response["retryAt"] = v.RetryAt
+	}
+	return response
+}
+
+// checkReservationPolicy must run inside the reservation transaction. It locks
+// the person row first, so concurrent reservations by the same person are
+// checked one after another and cannot both slip under the limits.
+func checkReservationPolicy(tx *gorm.DB, personID string, topicID int, serviceDay time.Time) (*PolicyViolation, error) {
+	var person models.Person
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&person, "id = ?", personID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	config, err := getConfig(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var activeQueues []models.Queue
+	err = tx.Preload("Topic").
+		Where("person_id = ? AND service_day = ? AND status IN ?", personID, serviceDay, helpers.ACTIVE_STATUSES).
+		Order("created_at DESC, id DESC").
+		Find(&activeQueues).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MaxActivePerTopic > 0 {
+		var sameTopic []models.Queue
+		for _, queue := range activeQueues {
+			if queue.TopicID == topicID {
+				sameTopic = append(sameTopic, queue)
+			}
+		}
+		if len(sameTopic) >= config.MaxActivePerTopic {
+			return &PolicyViolation{
+				Code:    ACTIVE_TICKET_FOR_TOPIC,
+				Message: fmt.Sprintf("You already have queue %s for this topic", sameTopic[0].No),
+				Queue:   &sameTopic[0],
+			}, nil
+		}
+	}
+
+	if config.MaxActivePerPerson > 0 && len(activeQueues) >= config.MaxActivePerPerson {
+		return &PolicyViolation{
+			Code:    TOO_MANY_ACTIVE_TICKETS,
+			Message: fmt.Sprintf("You can hold at most %d active queues at a time", config.MaxActivePerPerson),
+			Queue:   &activeQueues[0],
+		}, nil
+	}
+
+	if config.NoShowCooldownMinutes > 0 {
+		var lastNoShow *time.Time
+		err := tx.Model(&models.QueueTransition{}).
+			Joins("JOIN queues ON queues.id = queue_transitions.queue_id").
+			Where("queues.person_id = ? AND queues.deleted_at IS NULL AND queue_transitions.to_status = ?", personID, helpers.NO_SHOW).
+			Select("MAX(queue_transitions.created_at)").
+			Scan(&lastNoShow).Error
+		if err != nil {
+			return nil, err
+		}
+		if lastNoShow != nil {
+			retryAt := lastNoShow.Add(time.Duration(config.NoShowCooldownMinutes) * time.Minute)
+			if time.Now().Before(retryAt) {
+				return &PolicyViolation{
+					Code:    NO_SHOW_COOLDOWN,
+					Message: "You missed your last queue, please wait before reserving again",
+					RetryAt: &retryAt,
+				}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
@@ -5,29 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"src/helpers"
 	"src/models"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ReserveDTO struct {
-	Topic     int     `json:"topic" validate:"required"`
-	Note      *string `json:"note"`
-	FirstName *string `json:"firstName"`
-	LastName  *string `json:"lastName"`
+	Topic int     `json:"topic" validate:"required"`
+	Note  *string `json:"note"`
 }
 
 func GetQueues(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		counterID := c.Query("counter")
+		counterIDQuery := c.Query("counter")
 
-		today := helpers.GetBangkokTime().Format("2006-01-02")
-
-		if counterID == "" {
+		if counterIDQuery == "" {
 			var queues []models.Queue
-			if err := db.Preload("Topic").Where("AND DATE(created_at) = ?", today).Find(&queues).Error; err != nil {
+			if err := db.Preload("Topic").
+				Where("DATE(created_at) = ?", helpers.GetBangkokTime().Format("2006-01-02")).
+				Order("created_at ASC, id ASC").
+				Find(&queues).Error; err != nil {
 				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queues")
 				return
 			}
@@ -35,11 +39,14 @@ func GetQueues(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var waitingQueues []models.Queue
-		if err := db.Preload("Topic").
-			Where("status = ? AND topic_id IN (SELECT topic_id FROM counter_topics WHERE counter_id = ?)", helpers.WAITING, counterID).
-			Order("created_at ASC, no ASC").
-			Find(&waitingQueues).Error; err != nil {
+		counterID, err := strconv.Atoi(counterIDQuery)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid counter")
+			return
+		}
+
+		waitingQueues, err := orderedWaitingQueues(db, counterID)
+		if err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch waiting queues")
 			return
 		}
@@ -50,18 +57,12 @@ func GetQueues(db *gorm.DB) gin.HandlerFunc {
 
 func GetStudentQueue(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		firstName := c.Query("firstName")
-		lastName := c.Query("lastName")
-		if firstName == "" || lastName == "" {
-			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Missing required parameters: firstName and lastName")
-			return
-		}
+		claims := helpers.GetClaims(c)
 
 		today := helpers.GetBangkokTime().Format("2006-01-02")
 
 		var queue models.Queue
-		var topic models.Topic
-		err := db.Preload("Topic").Where("firstname = ? AND lastname = ? AND DATE(created_at) = ? AND feedback = ?", firstName, lastName, today, false).Order("created_at DESC, no DESC").First(&queue).Error
+		err := db.Preload("Topic").Where("person_id = ? AND DATE(created_at) = ? AND feedback = ?", claims.Subject, today, false).Order("created_at DESC, no DESC").First(&queue).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
 				helpers.FormatSuccessResponse(c, map[string]interface{}{"queue": map[string]interface{}{}})
@@ -71,15 +72,17 @@ func GetStudentQueue(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		countWaitingAfterInProgress, err := FindWaitingQueue(db, int(topic.ID), int(queue.ID), topic.Code)
+		estimate, err := EstimateQueue(db, queue)
 		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to count waiting queues")
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to estimate waiting time")
 			return
 		}
 
 		helpers.FormatSuccessResponse(c, map[string]interface{}{
-			"queue":   queue,
-			"waiting": countWaitingAfterInProgress})
+			"queue":    queue,
+			"waiting":  estimate.Position,
+			"estimate": estimate,
+		})
 	}
 }
 
@@ -87,7 +90,7 @@ func GetCalledQueues(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var calledQueues []models.Queue
 		if err := db.Preload("Topic").
-			Where("status = ?", helpers.CALLED).
+			Where("status = ?", helpers.COMPLETED).
 			Order("created_at DESC, no DESC").
 			Find(&calledQueues).Error; err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch waiting queues")
@@ -109,124 +112,183 @@ func CreateQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		var topic models.Topic
 		err := db.First(&topic, body.Topic).Error
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helpers.FormatErrorResponse(c, http.StatusNotFound, "Topic not found")
+				return
+			}
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve topic")
 			return
 		}
 
-		today := helpers.GetBangkokTime().Format("2006-01-02")
+		var note *string
+		if body.Note == nil {
+			note = nil
+		} else {
+			note = body.Note
+		}
 
-		var lastQueueNo string
-		err = db.Model(&models.Queue{}).Where("topic_id = ? AND DATE(created_at) = ?", body.Topic, today).Order("no DESC").Limit(1).Pluck("no", &lastQueueNo).Error
-		if err != nil && err != gorm.ErrRecordNotFound {
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve the last queue number")
+		idempotencyKey := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
 			return
 		}
 
-		var newQueueNo string
-		if lastQueueNo != "" {
-			var numPart int
-			_, err := fmt.Sscanf(lastQueueNo, topic.Code+"%03d", &numPart)
-			if err != nil {
-				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to parse the last queue number")
+		claims := helpers.GetClaims(c)
+		var studentID *string
+		if claims.Role == helpers.GUEST {
+			if !requireGuestLogin(c, db) {
 				return
 			}
-			numPart++
-			newQueueNo = fmt.Sprintf("%s%03d", topic.Code, numPart)
 		} else {
-			newQueueNo = fmt.Sprintf("%s001", topic.Code)
+			if claims.StudentID == "" {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid studentId in token")
+				return
+			}
+			studentIDClaim := claims.StudentID
+			studentID = &studentIDClaim
 		}
 
-		var note *string
-		if body.Note == nil {
-			note = nil
-		} else {
-			note = body.Note
+		serviceDay := helpers.GetServiceDay()
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
 		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
 
-		var firstName, lastName string
-		var studentID *string
-		if body.FirstName != nil && body.LastName != nil {
-			firstName = *body.FirstName
-			lastName = *body.LastName
-		} else {
-			claims, err := helpers.ExtractToken(c)
+		// A retry with the same key gets the ticket the first request made
+		// instead of another number.
+		if idempotencyKey != "" {
+			previous, err := claimIdempotencyKey(tx, claims.Subject, idempotencyKey, hashIdempotentRequest(body))
 			if err != nil {
-				helpers.FormatErrorResponse(c, http.StatusUnauthorized, err.Error())
+				tx.Rollback()
+				if errors.Is(err, ErrIdempotencyKeyReused) {
+					helpers.FormatErrorResponse(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+					return
+				}
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check Idempotency-Key")
 				return
 			}
-			firstNameClaim, ok := (*claims)["firstName"].(string)
-			if !ok {
-				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid firstName in token")
+			if previous != nil {
+				tx.Rollback()
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(previous.StatusCode, "application/json; charset=utf-8", []byte(previous.Response))
 				return
 			}
-			lastNameClaim, ok := (*claims)["lastName"].(string)
-			if !ok {
-				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid lastName in token")
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&topic, topic.ID).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve topic")
+			return
+		}
+		violation, err := checkTopicAvailability(tx, topic, helpers.GetBangkokTime())
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check topic availability")
+			return
+		}
+		if violation == nil {
+			violation, err = checkReservationPolicy(tx, claims.Subject, topic.ID, serviceDay)
+			if err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check reservation policy")
 				return
 			}
-			studentIDClaim, ok := (*claims)["studentId"].(string)
-			if !ok {
-				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid studentId in token")
+		}
+		if violation == nil {
+			violation, err = checkWalkInCapacity(tx, topic.ID, helpers.GetBangkokTime())
+			if err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check walk-in capacity")
 				return
 			}
-			studentID = &studentIDClaim
-			firstName = firstNameClaim
-			lastName = lastNameClaim
+		}
+		if violation != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, violation.Response())
+			return
 		}
 
+		newQueueNo, err := allocateQueueNo(tx, topic, serviceDay)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to allocate a queue number")
+			return
+		}
+
+		config, err := getConfig(tx)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+		code := helpers.NewTicketCode()
 		queue := models.Queue{
-			No:        newQueueNo,
-			StudentID: studentID,
-			Firstname: firstName,
-			Lastname:  lastName,
-			TopicID:   body.Topic,
-			Note:      note,
+			No:         newQueueNo,
+			Code:       &code,
+			ServiceDay: &serviceDay,
+			PersonID:   &claims.Subject,
+			StudentID:  studentID,
+			Firstname:  claims.FirstName,
+			Lastname:   claims.LastName,
+			TopicID:    body.Topic,
+			Note:       note,
+			Priority:   topic.DefaultPriority,
+			// Reservations come from the student's own device, so they are
+			// remote until checked in at the kiosk or counter.
+			RequiresCheckIn: config.RequireCheckIn,
 		}
 
-		if err := db.Create(&queue).Error; err != nil {
+		if err := tx.Create(&queue).Error; err != nil {
+			tx.Rollback()
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to create queue")
 			return
 		}
 
-		countWaitingAfterInProgress, err := FindWaitingQueue(db, body.Topic, queue.ID, topic.Code)
+		err = tx.Model(&queue).Preload("Topic").First(&queue).Error
 		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to count waiting queues")
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue details")
 			return
 		}
 
-		err = db.Model(&queue).Preload("Topic").First(&queue).Error
+		estimate, err := EstimateQueue(tx, queue)
 		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue details")
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to estimate waiting time")
 			return
 		}
-		message, _ := json.Marshal(map[string]interface{}{
-			"event": "addQueue",
-			"data": map[string]interface{}{
-				"queue":   queue,
-				"waiting": countWaitingAfterInProgress,
-			},
-		})
-		hub.broadcast <- message
 
-		if body.FirstName != nil && body.LastName != nil {
-			tokenString, err := generateJWTToken(body, true)
+		response := map[string]interface{}{
+			"queue":    queue,
+			"waiting":  estimate.Position,
+			"estimate": estimate,
+		}
+		if idempotencyKey != "" {
+			err := saveIdempotentResponse(tx, claims.Subject, idempotencyKey, queue.ID, http.StatusOK, helpers.SuccessBody(response))
 			if err != nil {
-				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate JWT token")
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to save Idempotency-Key")
 				return
 			}
-
-			helpers.FormatSuccessResponse(c, map[string]interface{}{
-				"token":   tokenString,
-				"queue":   queue,
-				"waiting": countWaitingAfterInProgress,
-			})
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
 			return
 		}
 
-		helpers.FormatSuccessResponse(c, map[string]interface{}{
-			"queue":   queue,
-			"waiting": countWaitingAfterInProgress,
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "addQueue",
+			"data":  response,
 		})
+		hub.broadcast <- message
+
+		helpers.FormatSuccessResponse(c, response)
 	}
 }
 
@@ -241,18 +303,56 @@ func UpdateQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
 			return
 		}
+		claims := helpers.GetClaims(c)
+		if !canOperateCounter(claims, body.Counter) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate your own counter")
+			return
+		}
+		actor := actorFromClaims(claims)
 		tx := db.Begin()
-		if err := tx.Model(&models.Queue{}).Where("id = ?", body.Current).Update("status", helpers.CALLED).Error; err != nil {
+		var nextQueue models.Queue
+		if err := tx.First(&nextQueue, id).Error; err != nil {
 			tx.Rollback()
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update current queue to CALLED")
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
 			return
 		}
-		if err := tx.Model(&models.Queue{}).Where("id = ?", id).Updates(map[string]interface{}{
-			"status":     helpers.IN_PROGRESS,
+		// Calling a ticket by hand is held to the same rules as call-next.
+		err := callableQueuesForCounter(tx, body.Counter).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("queues.id = ?", nextQueue.ID).
+			Take(&nextQueue).Error
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helpers.FormatErrorResponse(c, http.StatusConflict, "Queue cannot be called at this counter, it may be on hold, not checked in or of another topic")
+				return
+			}
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		if body.Current != 0 {
+			var previousQueue models.Queue
+			err := tx.Where("id = ? AND counter_id = ?", body.Current, body.Counter).First(&previousQueue).Error
+			if err != nil {
+				tx.Rollback()
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					helpers.FormatErrorResponse(c, http.StatusConflict, "Current queue does not belong to this counter")
+					return
+				}
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch current queue")
+				return
+			}
+			if err := TransitionQueue(tx, &previousQueue, finishedStatus(previousQueue.Status), actor, "Next queue called", nil); err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to finish current queue: "+err.Error())
+				return
+			}
+		}
+		if err := TransitionQueue(tx, &nextQueue, helpers.CALLING, actor, "", map[string]interface{}{
 			"counter_id": body.Counter,
-		}).Error; err != nil {
+		}); err != nil {
 			tx.Rollback()
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update queue to IN_PROGRESS")
+			helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to call queue: "+err.Error())
 			return
 		}
 		var currentQueue models.Queue
@@ -269,8 +369,9 @@ func UpdateQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		message, _ := json.Marshal(map[string]interface{}{
 			"event": "updateQueue",
 			"data": map[string]interface{}{
-				"current": currentQueue,
-				"called":  body.Current,
+				"current":   currentQueue,
+				"called":    body.Current,
+				"estimates": EstimateCounterQueues(db, body.Counter),
 			},
 		})
 		hub.broadcast <- message
@@ -279,51 +380,389 @@ func UpdateQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	}
 }
 
+func UpdateQueueStatus(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		body := new(struct {
+			Status helpers.STATUS `json:"status"`
+			Reason string         `json:"reason"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.Status == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if body.Status == helpers.CALLING {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Queues are called through their counter")
+			return
+		}
+
+		var queue models.Queue
+		if err := db.First(&queue, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		allowed, err := canOperateQueue(db, claims, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+
+		var updates map[string]interface{}
+		if body.Status == helpers.WAITING {
+			updates = map[string]interface{}{"counter_id": nil, "held_at": nil}
+		}
+		tx := db.Begin()
+		if err := TransitionQueue(tx, &queue, body.Status, actorFromClaims(claims), body.Reason, updates); err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to update queue status: "+err.Error())
+			return
+		}
+		if err := tx.Preload("Topic").First(&queue, id).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "updateQueueStatus",
+			"data":  queue,
+		})
+		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
+
+		helpers.FormatSuccessResponse(c, queue)
+	}
+}
+
+func UpdateQueuePriority(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		body := new(struct {
+			Priority *int `json:"priority"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.Priority == nil || !isValidPriority(*body.Priority) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid priority")
+			return
+		}
+
+		var queue models.Queue
+		if err := db.First(&queue, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
+		allowed, err := canOperateQueue(db, helpers.GetClaims(c), queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+
+		result := db.Model(&models.Queue{}).
+			Where("id = ? AND status = ?", queue.ID, helpers.WAITING).
+			Update("priority", *body.Priority)
+		if result.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update queue priority")
+			return
+		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Only waiting queues can be reprioritized")
+			return
+		}
+		if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "updateQueuePriority",
+			"data":  queue,
+		})
+		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
+
+		helpers.FormatSuccessResponse(c, queue)
+	}
+}
+
+func GetQueueTransitions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var transitions []models.QueueTransition
+		if err := db.Where("queue_id = ?", id).Order("created_at ASC, id ASC").Find(&transitions).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue transitions")
+			return
+		}
+		helpers.FormatSuccessResponse(c, transitions)
+	}
+}
+
+// DeleteQueue soft-deletes a ticket, recording who removed it and why. The
+// ticket drops out of live lists but stays in history and can be restored
+// with RestoreQueue for a while.
 func DeleteQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		if err := db.Delete(&models.Queue{}, id).Error; err != nil {
+		body := new(struct {
+			Reason string `json:"reason"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		reason := strings.TrimSpace(body.Reason)
+		if reason == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "A reason is required to delete a queue")
+			return
+		}
+		if len(reason) > 255 {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Reason must be at most 255 characters")
+			return
+		}
+
+		var queue models.Queue
+		if err := db.First(&queue, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+				return
+			}
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		allowed, err := canOperateQueue(db, claims, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+
+		updates := map[string]interface{}{
+			"deleted_at":    time.Now(),
+			"delete_reason": reason,
+		}
+		if claims.UserID != 0 {
+			updates["deleted_by_user_id"] = claims.UserID
+		}
+		result := db.Model(&models.Queue{}).Where("id = ?", queue.ID).Updates(updates)
+		if result.Error != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to delete queue")
 			return
 		}
+		if result.RowsAffected == 0 {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
 
 		message, _ := json.Marshal(map[string]interface{}{
 			"event": "deleteQueue",
 			"data":  id,
 		})
 		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
 
 		helpers.FormatSuccessResponse(c, map[string]string{"message": "Queue deleted successfully"})
 	}
 }
 
-func UpdateQueueFeedback(db *gorm.DB) gin.HandlerFunc {
+// RestoreQueue undoes a deletion within the configured undo window. The ticket
+// keeps its original enqueue time, so a waiting ticket returns to the position
+// it had. A ticket deleted at a counter that has since moved on goes back to
+// waiting for that counter.
+func RestoreQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
 		var queue models.Queue
-		if err := db.First(&queue, "id = ?", id).Error; err != nil {
+		if err := db.Unscoped().First(&queue, "id = ?", c.Param("id")).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
 				return
 			}
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue")
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		allowed, err := canOperateQueue(db, claims, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+		if !queue.DeletedAt.Valid {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Queue is not deleted")
+			return
+		}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+		if time.Since(queue.DeletedAt.Time) > time.Duration(config.DeleteUndoMinutes)*time.Minute {
+			helpers.FormatErrorResponse(c, http.StatusConflict, fmt.Sprintf("Queues can only be restored within %d minutes of deletion", config.DeleteUndoMinutes))
 			return
 		}
 
-		if err := db.Model(&queue).Update("feedback", true).Error; err != nil {
-			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update queue feedback")
+		updates := map[string]interface{}{
+			"deleted_at":  nil,
+			"restored_at": time.Now(),
+		}
+		if claims.UserID != 0 {
+			updates["restored_by_user_id"] = claims.UserID
+		}
+
+		tx := db.Begin()
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+			}
+		}()
+
+		result := tx.Unscoped().Model(&models.Queue{}).
+			Where("id = ? AND deleted_at IS NOT NULL", queue.ID).
+			Updates(updates)
+		if result.Error != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to restore queue")
 			return
 		}
-		helpers.FormatSuccessResponse(c, map[string]string{"message": "Queue updated successfully"})
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Queue was changed by someone else")
+			return
+		}
+
+		if queue.CounterID != nil && slices.Contains(helpers.AT_COUNTER_STATUSES, queue.Status) {
+			var busy int64
+			err := tx.Model(&models.Queue{}).
+				Where("counter_id = ? AND status IN ? AND id <> ?", *queue.CounterID, helpers.AT_COUNTER_STATUSES, queue.ID).
+				Count(&busy).Error
+			if err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check counter")
+				return
+			}
+			if busy > 0 {
+				if err := TransitionQueue(tx, &queue, helpers.WAITING, actorFromClaims(claims), "Restored after the counter moved on", nil); err != nil {
+					tx.Rollback()
+					helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to restore queue: "+err.Error())
+					return
+				}
+			}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+		if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "restoreQueue",
+			"data":  queue,
+		})
+		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
+
+		helpers.FormatSuccessResponse(c, queue)
+	}
+}
+
+// findOwnQueue loads the queue in the path and makes sure it was reserved by
+// the signed-in person, writing the error response when it was not.
+func findOwnQueue(c *gin.Context, db *gorm.DB) (models.Queue, bool) {
+	var queue models.Queue
+	if err := db.First(&queue, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return queue, false
+		}
+		helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve queue")
+		return queue, false
+	}
+	if queue.PersonID == nil || *queue.PersonID != helpers.GetClaims(c).Subject {
+		helpers.FormatErrorResponse(c, http.StatusForbidden, "Queue does not belong to you")
+		return queue, false
+	}
+	return queue, true
+}
+
+func CancelOwnQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findOwnQueue(c, db)
+		if !ok {
+			return
+		}
+		if queue.Status == helpers.SERVING {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Queue is already being served")
+			return
+		}
+		body := new(struct {
+			Reason string `json:"reason"`
+		})
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(body); err != nil {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		tx := db.Begin()
+		if err := TransitionQueue(tx, &queue, helpers.CANCELLED, actorFromClaims(helpers.GetClaims(c)), body.Reason, nil); err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to cancel queue: "+err.Error())
+			return
+		}
+		if err := tx.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "cancelQueue",
+			"data":  queue,
+		})
+		hub.broadcast <- message
+		BroadcastEstimates(db, hub, queue.TopicID)
+
+		helpers.FormatSuccessResponse(c, queue)
 	}
 }
 
-func FindWaitingQueue(db *gorm.DB, topicID int, queueID int, topicCode string) (int, error) {
-	var count int64
-	if err := db.Model(&models.Queue{}).
-		Where("topic_id = ? AND status IN ? AND id != ? AND no LIKE ?", topicID, []string{string(helpers.WAITING), string(helpers.IN_PROGRESS)}, queueID, topicCode+"%").
-		Count(&count).Error; err != nil {
-		return 0, err
+func UpdateQueueFeedback(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findOwnQueue(c, db)
+		if !ok {
+			return
+		}
+
+		if err := db.Model(&queue).Update("feedback", true).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update queue feedback")
+			return
+		}
+		helpers.FormatSuccessResponse(c, map[string]string{"message": "Queue updated successfully"})
 	}
-	return int(count), nil
 }
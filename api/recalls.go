@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OfferNextQueue tells the counter which ticket call-next would pick now
+// without calling it, so staff can move on after a no-show.
+func OfferNextQueue(db *gorm.DB, hub *Hub, counterID int) {
+	queues, err := orderedWaitingQueues(db, counterID)
+	if err != nil {
+		log.Printf("Error fetching next queue for counter %d: %v", counterID, err)
+		return
+	}
+	var next *models.Queue
+	if len(queues) > 0 {
+		next = &queues[0]
+	}
+	message, _ := json.Marshal(map[string]interface{}{
+		"event": "offerNextQueue",
+		"data": map[string]interface{}{
+			"counterId": counterID,
+			"queue":     next,
+		},
+	})
+	hub.broadcast <- message
+}
+
+func BroadcastNoShow(db *gorm.DB, hub *Hub, queue models.Queue, counterID int, reason string) {
+	message, _ := json.Marshal(map[string]interface{}{
+		"event": "noShowQueue",
+		"data": map[string]interface{}{
+			"queue":  queue,
+			"reason": reason,
+		},
+	})
+	hub.broadcast <- message
+	OfferNextQueue(db, hub, counterID)
+	BroadcastEstimates(db, hub, queue.TopicID)
+}
+
+// recallQueue calls a ticket at the counter again and restarts its no-show
+// timer. A ticket that has already used up its recalls is marked NO_SHOW
+// instead and the counter is offered the next ticket. An empty notification
+// sends the default push message.
+func recallQueue(db *gorm.DB, hub *Hub, queue models.Queue, config models.Config, actor Actor, notification string) (map[string]interface{}, int, error) {
+	if queue.Status != helpers.CALLING || queue.CounterID == nil {
+		return nil, http.StatusConflict, errors.New("Only a queue being called can be recalled")
+	}
+	if recallsExhausted(queue, config) {
+		reason := fmt.Sprintf("No show after %d recalls", queue.RecallCount)
+		if err := TransitionQueue(db, &queue, helpers.NO_SHOW, actor, reason, nil); err != nil {
+			return nil, transitionErrorStatus(err), err
+		}
+		BroadcastNoShow(db, hub, queue, *queue.CounterID, reason)
+		return map[string]interface{}{
+			"no":          queue.No,
+			"queue":       queue,
+			"recallCount": queue.RecallCount,
+			"maxRecalls":  config.MaxRecalls,
+		}, http.StatusOK, nil
+	}
+	counterID := *queue.CounterID
+
+	result := db.Model(&models.Queue{}).
+		Where("id = ? AND status = ? AND recall_count = ?", queue.ID, helpers.CALLING, queue.RecallCount).
+		Updates(map[string]interface{}{
+			"recall_count":     queue.RecallCount + 1,
+			"last_recalled_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to recall queue")
+	}
+	if result.RowsAffected == 0 {
+		return nil, http.StatusConflict, errors.New("Queue was changed by someone else")
+	}
+	if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to fetch queue")
+	}
+	var counter models.Counter
+	if err := db.First(&counter, counterID).Error; err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to fetch counter")
+	}
+
+	recall := map[string]interface{}{
+		"no":          queue.No,
+		"counter":     counter.Counter,
+		"queue":       queue,
+		"recallCount": queue.RecallCount,
+		"maxRecalls":  config.MaxRecalls,
+		"noShowAt":    noShowAt(queue, config),
+	}
+	message, _ := json.Marshal(map[string]interface{}{
+		"event": "recallQueue",
+		"data":  recall,
+	})
+	hub.broadcast <- message
+
+	if queue.PersonID != nil {
+		personID := *queue.PersonID
+		if notification == "" {
+			raw, _ := json.Marshal(map[string]string{
+				"title": fmt.Sprintf("Queue %s, please come to %s", queue.No, counter.Counter),
+				"body":  "You are being called again. Your queue will be skipped if you do not show up.",
+			})
+			notification = string(raw)
+		}
+		go func() {
+			if err := SendPushNotification(db, notification, personID); err != nil {
+				log.Printf("Error sending recall notification for queue %d: %v", queue.ID, err)
+			}
+		}()
+	}
+
+	return recall, http.StatusOK, nil
+}
+
+func recallsExhausted(queue models.Queue, config models.Config) bool {
+	return queue.RecallCount >= config.MaxRecalls
+}
+
+// noShowAt is when the no-show timer marks the ticket NO_SHOW. The timer starts
+// when the ticket is called and restarts on every recall.
+func noShowAt(queue models.Queue, config models.Config) *time.Time {
+	last := queue.CalledAt
+	if queue.LastRecalledAt != nil {
+		last = queue.LastRecalledAt
+	}
+	if last == nil {
+		return nil
+	}
+	at := last.Add(time.Duration(config.NoShowTimeoutMinutes) * time.Minute)
+	return &at
+}
+
+func RecallQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var queue models.Queue
+		if err := db.First(&queue, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		allowed, err := canOperateQueue(db, claims, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+		config, err := getConfig(db)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+			return
+		}
+
+		recall, status, err := recallQueue(db, hub, queue, config, actorFromClaims(claims), "")
+		if err != nil {
+			helpers.FormatErrorResponse(c, status, err.Error())
+			return
+		}
+		helpers.FormatSuccessResponse(c, recall)
+	}
+}
+
+// ExpireCalledQueues marks every ticket that has gone unanswered for the
+// no-show timeout since it was last called or recalled as NO_SHOW and returns
+// them.
+func ExpireCalledQueues(tx *gorm.DB) ([]models.Queue, error) {
+	config, err := getConfig(tx)
+	if err != nil {
+		return nil, err
+	}
+	threshold := time.Now().Add(-time.Duration(config.NoShowTimeoutMinutes) * time.Minute)
+
+	var queues []models.Queue
+	err = tx.Where("status = ? AND COALESCE(last_recalled_at, called_at) < ?", helpers.CALLING, threshold).
+		Find(&queues).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range queues {
+		reason := "No show after being called"
+		if queues[i].RecallCount > 0 {
+			reason = fmt.Sprintf("No show after %d recalls", queues[i].RecallCount)
+		}
+		if err := TransitionQueue(tx, &queues[i], helpers.NO_SHOW, SystemActor, reason, nil); err != nil {
+			return nil, err
+		}
+	}
+	return queues, nil
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"src/models"
+	"testing"
+	"time"
+)
+
+func TestNoShowAt(t *testing.T) {
+	config := models.Config{MaxRecalls: 3, NoShowTimeoutMinutes: 5}
+	calledAt := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	recalledAt := calledAt.Add(4 * time.Minute)
+
+	tests := []struct {
+		name  string
+		queue models.Queue
+		want  *time.Time
+	}{
+		{"not called", models.Queue{}, nil},
+		{"called and never recalled", models.Queue{CalledAt: &calledAt}, ptr(calledAt.Add(5 * time.Minute))},
+		{"recalled", models.Queue{CalledAt: &calledAt, LastRecalledAt: &recalledAt, RecallCount: 1}, ptr(recalledAt.Add(5 * time.Minute))},
+		{"out of recalls", models.Queue{CalledAt: &calledAt, LastRecalledAt: &recalledAt, RecallCount: 3}, ptr(recalledAt.Add(5 * time.Minute))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := noShowAt(tt.queue, config)
+			if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+				t.Errorf("noShowAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecallsExhausted(t *testing.T) {
+	config := models.Config{MaxRecalls: 3, NoShowTimeoutMinutes: 5}
+	for count, want := range []bool{false, false, false, true, true} {
+		if got := recallsExhausted(models.Queue{RecallCount: count}, config); got != want {
+			t.Errorf("recallsExhausted() with %d recalls = %v, want %v", count, got, want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"log"
 	"net/http"
+	"os"
 	"src/helpers"
 	"src/models"
 
@@ -12,21 +14,7 @@ import (
 
 func SaveSubscription(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		claims, err := helpers.ExtractToken(c)
-		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusUnauthorized, err.Error())
-			return
-		}
-		firstName, ok := (*claims)["firstName"].(string)
-		if !ok {
-			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid firstName in token")
-			return
-		}
-		lastName, ok := (*claims)["lastName"].(string)
-		if !ok {
-			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid lastName in token")
-			return
-		}
+		claims := helpers.GetClaims(c)
 
 		var subscriptionPayload struct {
 			Endpoint string `json:"endpoint"`
@@ -41,15 +29,14 @@ func SaveSubscription(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		subscription := models.Subscription{
-			FirstName: firstName,
-			LastName:  lastName,
-			Endpoint:  subscriptionPayload.Endpoint,
-			Auth:      subscriptionPayload.Keys.Auth,
-			P256dh:    subscriptionPayload.Keys.P256dh,
+			PersonID: claims.Subject,
+			Endpoint: subscriptionPayload.Endpoint,
+			Auth:     subscriptionPayload.Keys.Auth,
+			P256dh:   subscriptionPayload.Keys.P256dh,
 		}
-		err = db.Clauses(
+		err := db.Clauses(
 			clause.OnConflict{
-				Columns:   []clause.Column{{Name: "first_name"}, {Name: "last_name"}},
+				Columns:   []clause.Column{{Name: "person_id"}},
 				DoUpdates: clause.AssignmentColumns([]string{"endpoint", "auth", "p256dh"}),
 			},
 		).Create(&subscription).Error
@@ -63,34 +50,90 @@ func SaveSubscription(db *gorm.DB) gin.HandlerFunc {
 }
 
 func RegisterRoutes(r *gin.RouterGroup, db *gorm.DB, hub *Hub) {
-	r.POST("/subscribe", SaveSubscription(db))
-	r.POST("/send-notification", SendNotificationTrigger(db, hub))
+	superAdmin := AuthMiddleware(helpers.SUPER_ADMIN)
+	staff := AuthMiddleware(helpers.SUPER_ADMIN, helpers.COUNTER_STAFF)
+	viewer := AuthMiddleware(helpers.STAFF_ROLES...)
+	student := AuthMiddleware(helpers.STUDENT, helpers.GUEST)
+	kioskOrStaff := KioskOrAuthMiddleware(helpers.SUPER_ADMIN, helpers.COUNTER_STAFF)
 
-	r.POST("/authentication", Authentication(db))
+	r.POST("/subscribe", student, SaveSubscription(db))
+	r.POST("/send-notification", staff, SendNotificationTrigger(db, hub))
+
+	idp, err := NewIdentityProvider(os.Getenv("IDENTITY_PROVIDER"))
+	if err != nil {
+		log.Fatalf("Failed to configure identity provider: %v", err)
+	}
+	if mock, ok := idp.(*MockIdentityProvider); ok {
+		r.GET("/auth/mock/authorize", MockAuthorize(mock))
+	}
+	r.POST("/authentication", Authentication(db, idp))
+
+	mailer, err := NewMailSender(os.Getenv("MAIL_SENDER"))
+	if err != nil {
+		log.Fatalf("Failed to configure mail sender: %v", err)
+	}
+	r.POST("/auth/guest/request-code", RequestGuestCode(db, mailer))
+	r.POST("/auth/guest/verify", VerifyGuestCode(db))
+	r.POST("/auth/refresh", RefreshSession(db))
+	r.POST("/auth/logout", Logout(db))
+	r.POST("/auth/revoke", superAdmin, RevokeUserSessions(db))
 
 	r.GET("/config", GetConfig(db))
-	r.PUT("/config/login-not-cmu", SetLoginNotCmu(db, hub))
+	r.PUT("/config/login-not-cmu", superAdmin, SetLoginNotCmu(db, hub))
+	r.PUT("/config/queue-policy", superAdmin, UpdateQueuePolicy(db, hub))
 
-	r.GET("/user", GetUserInfo(db))
+	r.GET("/user", AuthMiddleware(), GetUserInfo(db))
+	r.GET("/users", viewer, GetUsers(db))
+	r.POST("/user", superAdmin, CreateUser(db))
+	r.PUT("/user/:id", superAdmin, UpdateUser(db))
+	r.DELETE("/user/:id", superAdmin, DeleteUser(db))
 
 	r.GET("/counter", GetCounters(db))
-	r.POST("/counter", CreateCounter(db, hub))
-	r.PUT("/counter/:id", UpdateCounter(db, hub))
-	r.DELETE("/counter/:id", DeleteCounter(db, hub))
+	r.POST("/counter", superAdmin, CreateCounter(db, hub))
+	r.PUT("/counter/:id", staff, UpdateCounter(db, hub))
+	r.POST("/counter/:id/call-next", staff, CallNextQueue(db, hub))
+	r.DELETE("/counter/:id", superAdmin, DeleteCounter(db, hub))
 
 	r.GET("/topic", GetTopics(db))
-	r.POST("/topic", CreateTopic(db, hub))
-	r.PUT("/topic/:id", UpdateTopic(db, hub))
-	r.DELETE("/topic/:id", DeleteTopic(db, hub))
+	r.POST("/topic", superAdmin, CreateTopic(db, hub))
+	r.PUT("/topic/:id", superAdmin, UpdateTopic(db, hub))
+	r.DELETE("/topic/:id", superAdmin, DeleteTopic(db, hub))
+	r.GET("/topic/:id/slots", GetTopicSlots(db))
 
-	r.GET("/queue", GetQueues(db))
-	r.GET("/queue/student", GetStudentQueue(db))
+	r.GET("/slot", GetAppointmentSlots(db))
+	r.POST("/slot", superAdmin, CreateAppointmentSlot(db, hub))
+	r.PUT("/slot/:id", superAdmin, UpdateAppointmentSlot(db, hub))
+	r.DELETE("/slot/:id", superAdmin, DeleteAppointmentSlot(db, hub))
+
+	r.GET("/appointment/student", student, GetStudentAppointments(db))
+	r.POST("/appointment", student, BookAppointment(db, hub))
+	r.POST("/appointment/:id/cancel", student, CancelAppointment(db, hub))
+	r.POST("/appointment/:id/check-in", student, CheckInAppointment(db, hub))
+
+	r.GET("/queue", viewer, GetQueues(db))
+	r.GET("/queue/history", viewer, SearchQueueHistory(db))
+	r.GET("/queue/stats", viewer, GetQueueStats(db))
+	r.GET("/queue/student", student, GetStudentQueue(db))
 	r.GET("/queue/called", GetCalledQueues(db))
-	r.PUT("/queue/feedback/:id", UpdateQueueFeedback(db))
-	r.POST("/queue", CreateQueue(db, hub))
-	r.PUT("/queue/:id", UpdateQueue(db, hub))
-	r.DELETE("/queue/:id", DeleteQueue(db, hub))
+	r.PUT("/queue/feedback/:id", student, UpdateQueueFeedback(db))
+	r.POST("/queue", student, CreateQueue(db, hub))
+	r.POST("/queue/:id/cancel", student, CancelOwnQueue(db, hub))
+	r.POST("/queue/:id/hold", student, HoldQueue(db, hub))
+	r.POST("/queue/:id/resume", student, ResumeQueue(db, hub))
+	r.PUT("/queue/:id", staff, UpdateQueue(db, hub))
+	r.PUT("/queue/:id/status", staff, UpdateQueueStatus(db, hub))
+	r.PUT("/queue/:id/priority", staff, UpdateQueuePriority(db, hub))
+	r.GET("/queue/:id/transitions", viewer, GetQueueTransitions(db))
+	r.GET("/queue/:id/ticket", KioskOrAuthMiddleware(), GetQueueTicket(db))
+	r.POST("/queue/:id/transfer", staff, TransferQueue(db, hub))
+	r.POST("/queue/:id/recall", staff, RecallQueue(db, hub))
+	r.GET("/queue/:id/transfers", viewer, GetQueueTransfers(db))
+	r.DELETE("/queue/:id", staff, DeleteQueue(db, hub))
+	r.POST("/queue/:id/restore", staff, RestoreQueue(db, hub))
+
+	r.GET("/ticket/:code", GetTicketByCode(db))
+	r.POST("/ticket/:code/check-in", kioskOrStaff, CheckInTicket(db, hub))
 
-	r.GET("/feedback", GetFeedbackByUser(db))
-	r.POST("/feedback", CreateFeedback(db))
+	r.GET("/feedback", viewer, GetFeedbackByUser(db))
+	r.POST("/feedback", student, CreateFeedback(db))
 }
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"slices"
+	"src/helpers"
+	"src/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// waitingQueuesForCounter matches waiting tickets of the counter's topics,
+// except those transferred straight to another counter, plus the tickets
+// transferred straight to this one.
+func waitingQueuesForCounter(db *gorm.DB, counterID int) *gorm.DB {
+	return db.Model(&models.Queue{}).
+		Where("queues.status = ?", helpers.WAITING).
+		Where("(queues.counter_id IS NULL AND queues.topic_id IN (SELECT topic_id FROM counter_topics WHERE counter_id = ?)) OR queues.counter_id = ?", counterID, counterID)
+}
+
+// callableQueuesForCounter narrows waitingQueuesForCounter to the tickets the
+// counter may call now: not on hold and checked in when check-in is required.
+func callableQueuesForCounter(db *gorm.DB, counterID int) *gorm.DB {
+	return waitingQueuesForCounter(db, counterID).
+		Where("queues.held_at IS NULL").
+		Where("queues.requires_check_in = ? OR queues.checked_in_at IS NOT NULL", false)
+}
+
+// isCallable mirrors the conditions call-next applies to a waiting ticket.
+func isCallable(queue models.Queue) bool {
+	return queue.HeldAt == nil && (!queue.RequiresCheckIn || queue.CheckedInAt != nil)
+}
+
+func priorityWeight(db *gorm.DB) (int, error) {
+	config, err := getConfig(db)
+	if err != nil {
+		return 0, err
+	}
+	return max(config.PriorityWeight, 1), nil
+}
+
+// priorityStreak counts how many of the counter's most recent calls in a row
+// were priority tickets, looking back at most weight calls.
+func priorityStreak(db *gorm.DB, counterID int, weight int) (int, error) {
+	var priorities []int
+	err := db.Model(&models.Queue{}).
+		Where("counter_id = ? AND called_at IS NOT NULL AND service_day = ?", counterID, helpers.GetServiceDay()).
+		Order("called_at DESC").
+		Limit(weight).
+		Pluck("priority", &priorities).Error
+	if err != nil {
+		return 0, err
+	}
+	streak := 0
+	for _, priority := range priorities {
+		if priority == helpers.PRIORITY_NORMAL {
+			break
+		}
+		streak++
+	}
+	return streak, nil
+}
+
+// interleaveByPriority orders waiting tickets the way they will be called:
+// priority tickets first, but after weight of them in a row the oldest normal
+// ticket gets its turn, so normal tickets are never starved. The input must be
+// in arrival order; streak is the number of priority calls already made.
+func interleaveByPriority(queues []models.Queue, weight int, streak int) []models.Queue {
+	var priority, normal []models.Queue
+	for _, queue := range queues {
+		if queue.Priority > helpers.PRIORITY_NORMAL {
+			priority = append(priority, queue)
+		} else {
+			normal = append(normal, queue)
+		}
+	}
+	slices.SortStableFunc(priority, func(a, b models.Queue) int {
+		return b.Priority - a.Priority
+	})
+
+	ordered := make([]models.Queue, 0, len(queues))
+	for len(priority) > 0 || len(normal) > 0 {
+		if len(priority) > 0 && (streak < weight || len(normal) == 0) {
+			ordered = append(ordered, priority[0])
+			priority = priority[1:]
+			streak++
+		} else {
+			ordered = append(ordered, normal[0])
+			normal = normal[1:]
+			streak = 0
+		}
+	}
+	return ordered
+}
+
+// orderedWaitingQueues returns the tickets the counter may call in calling
+// order, so the first one is what call-next picks. Held tickets and those not
+// checked in yet are left out.
+func orderedWaitingQueues(db *gorm.DB, counterID int) ([]models.Queue, error) {
+	var queues []models.Queue
+	if err := callableQueuesForCounter(db, counterID).Preload("Topic").
+		Order("queues.enqueued_at ASC, queues.id ASC").
+		Find(&queues).Error; err != nil {
+		return nil, err
+	}
+	weight, err := priorityWeight(db)
+	if err != nil {
+		return nil, err
+	}
+	streak, err := priorityStreak(db, counterID, weight)
+	if err != nil {
+		return nil, err
+	}
+	return interleaveByPriority(queues, weight, streak), nil
+}
+
+func lockNextQueue(tx *gorm.DB, counterID int, prioritized bool) (*models.Queue, error) {
+	query := callableQueuesForCounter(tx, counterID).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	if prioritized {
+		query = query.Where("queues.priority > ?", helpers.PRIORITY_NORMAL).
+			Order("queues.priority DESC, queues.enqueued_at ASC, queues.id ASC")
+	} else {
+		query = query.Where("queues.priority = ?", helpers.PRIORITY_NORMAL).
+			Order("queues.enqueued_at ASC, queues.id ASC")
+	}
+
+	var queue models.Queue
+	err := query.Take(&queue).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// selectNextQueue locks the next ticket the counter may call. Rows already
+// locked by another counter's call are skipped instead of waited on, so two
+// counters serving the same topic never get the same ticket.
+func selectNextQueue(tx *gorm.DB, counterID int) (*models.Queue, error) {
+	weight, err := priorityWeight(tx)
+	if err != nil {
+		return nil, err
+	}
+	streak, err := priorityStreak(tx, counterID, weight)
+	if err != nil {
+		return nil, err
+	}
+
+	prioritized := streak < weight
+	queue, err := lockNextQueue(tx, counterID, prioritized)
+	if err != nil || queue != nil {
+		return queue, err
+	}
+	return lockNextQueue(tx, counterID, !prioritized)
+}
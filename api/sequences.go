@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func isValidResetPeriod(period helpers.RESET_PERIOD) bool {
+	return period == helpers.DAILY || period == helpers.WEEKLY || period == helpers.NEVER
+}
+
+func sequencePeriodStart(period helpers.RESET_PERIOD, serviceDay time.Time) time.Time {
+	switch period {
+	case helpers.WEEKLY:
+		daysSinceMonday := (int(serviceDay.Weekday()) + 6) % 7
+		return serviceDay.AddDate(0, 0, -daysSinceMonday)
+	case helpers.NEVER:
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, serviceDay.Location())
+	default:
+		return serviceDay
+	}
+}
+
+func formatQueueNo(topic models.Topic, number int) string {
+	prefix := topic.Code
+	if topic.NumberPrefix != nil {
+		prefix = *topic.NumberPrefix
+	}
+	return fmt.Sprintf("%s%0*d", prefix, topic.NumberDigits, number)
+}
+
+// allocateQueueNo bumps the topic's counter for the current numbering period
+// and returns the next ticket number. It must run in the transaction that
+// inserts the queue so concurrent reservations are serialized on the
+// sequence row. Raising NumberStart past the current counter jumps ahead.
+func allocateQueueNo(tx *gorm.DB, topic models.Topic, serviceDay time.Time) (string, error) {
+	var lastNo int
+	err := tx.Raw(`
+		INSERT INTO queue_sequences (topic_id, period_start, last_no) VALUES (?, ?, ?)
+		ON CONFLICT (topic_id, period_start) DO UPDATE SET last_no = GREATEST(queue_sequences.last_no + 1, EXCLUDED.last_no)
+		RETURNING last_no`, topic.ID, sequencePeriodStart(topic.NumberReset, serviceDay), topic.NumberStart).Scan(&lastNo).Error
+	if err != nil {
+		return "", err
+	}
+	return formatQueueNo(topic, lastNo), nil
+}
+
+// carryOverSequence keeps numbering going when a topic switches reset period
+// so the new period cannot hand out a number already issued today.
+func carryOverSequence(tx *gorm.DB, topicID int, from, to helpers.RESET_PERIOD, serviceDay time.Time) error {
+	var previous models.QueueSequence
+	err := tx.Where("topic_id = ? AND period_start = ?", topicID, sequencePeriodStart(from, serviceDay)).First(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(`
+		INSERT INTO queue_sequences (topic_id, period_start, last_no) VALUES (?, ?, ?)
+		ON CONFLICT (topic_id, period_start) DO UPDATE SET last_no = GREATEST(queue_sequences.last_no, EXCLUDED.last_no)`,
+		topicID, sequencePeriodStart(to, serviceDay), previous.LastNo).Error
+}
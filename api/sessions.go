@@ -0,0 +1,200 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RefreshDTO struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRefreshToken(db *gorm.DB, claims helpers.Claims) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	claims.IssuedAt = nil
+	claims.ExpiresAt = nil
+	var email *string
+	if claims.Email != "" {
+		email = &claims.Email
+	}
+	refreshToken := models.RefreshToken{
+		TokenHash: hashRefreshToken(token),
+		Email:     email,
+		Claims:    claims,
+		ExpiresAt: time.Now().Add(helpers.GetEnvDuration("JWT_REFRESH_TOKEN_TTL", 7*24*time.Hour)),
+	}
+	if err := db.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func issueTokens(db *gorm.DB, claims helpers.Claims) (string, string, error) {
+	tokenString, err := generateJWTToken(claims)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := generateRefreshToken(db, claims)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, refreshToken, nil
+}
+
+func revokeSessions(db *gorm.DB, email string) (int64, error) {
+	result := db.Model(&models.RefreshToken{}).
+		Where("email = ? AND revoked_at IS NULL", email).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func RefreshSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body RefreshDTO
+		if err := c.ShouldBindJSON(&body); err != nil || body.RefreshToken == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
+
+		var session models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashRefreshToken(body.RefreshToken)).
+			First(&session).Error
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Invalid refresh token")
+				return
+			}
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve session")
+			return
+		}
+
+		if session.RevokedAt != nil {
+			// A rotated token being replayed means it leaked, so end every session of that account.
+			if session.Email != nil {
+				if _, err := revokeSessions(tx, *session.Email); err != nil {
+					tx.Rollback()
+					helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+					return
+				}
+			}
+			tx.Commit()
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Refresh token has been revoked")
+			return
+		}
+		if time.Now().After(session.ExpiresAt) {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Refresh token has expired")
+			return
+		}
+
+		if slices.Contains(helpers.STAFF_ROLES, session.Claims.Role) {
+			var user models.User
+			if err := tx.Where("email = ?", session.Claims.Email).First(&user).Error; err != nil {
+				tx.Rollback()
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					helpers.FormatErrorResponse(c, http.StatusForbidden, "Cannot access")
+					return
+				}
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve user")
+				return
+			}
+			applyStaffClaims(&session.Claims, user)
+		}
+
+		if err := tx.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to rotate refresh token")
+			return
+		}
+		tokenString, refreshToken, err := issueTokens(tx, session.Claims)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to generate JWT token")
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"token":        tokenString,
+			"refreshToken": refreshToken,
+		})
+	}
+}
+
+func Logout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body RefreshDTO
+		if err := c.ShouldBindJSON(&body); err != nil || body.RefreshToken == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+
+		err := db.Model(&models.RefreshToken{}).
+			Where("token_hash = ? AND revoked_at IS NULL", hashRefreshToken(body.RefreshToken)).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to revoke refresh token")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]string{"message": "Logged out successfully"})
+	}
+}
+
+func RevokeUserSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := new(struct {
+			Email string `json:"email"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.Email == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid email")
+			return
+		}
+
+		revoked, err := revokeSessions(db, body.Email)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{"revoked": revoked})
+	}
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,9 +14,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func SendPushNotification(db *gorm.DB, hub *Hub, message string, userIdentifier map[string]string, queue map[string]interface{}) error {
+func SendPushNotification(db *gorm.DB, message string, personID string) error {
 	var subscriptions []models.Subscription
-	err := db.Where("first_name = ? AND last_name = ?", userIdentifier["firstName"], userIdentifier["lastName"]).Find(&subscriptions).Error
+	err := db.Where("person_id = ?", personID).Find(&subscriptions).Error
 	if err != nil {
 		return fmt.Errorf("error fetching subscriptions: %v", err)
 	}
@@ -38,14 +38,6 @@ func SendPushNotification(db *gorm.DB, hub *Hub, message string, userIdentifier
 			},
 		}, options)
 
-		if queue != nil {
-			message, _ := json.Marshal(map[string]interface{}{
-				"event": "recallQueue",
-				"data":  queue,
-			})
-			hub.broadcast <- message
-		}
-
 		if err != nil {
 			log.Printf("Error sending notification to %s: %v", subscription.Endpoint, err)
 		} else {
@@ -61,31 +53,56 @@ func SendPushNotification(db *gorm.DB, hub *Hub, message string, userIdentifier
 func SendNotificationTrigger(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		body := new(struct {
-			No        *string `json:"no"`
-			Counter   *string `json:"counter"`
-			FirstName string  `json:"firstName"`
-			LastName  string  `json:"lastName"`
-			Message   string  `json:"message"`
+			No       *string `json:"no"`
+			Counter  *string `json:"counter"`
+			PersonID string  `json:"personId"`
+			Message  string  `json:"message"`
 		})
-		if err := c.Bind(body); err != nil {
+		if err := c.Bind(body); err != nil || body.PersonID == "" {
 			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
 			return
 		}
 
-		userIdentifier := map[string]string{
-			"firstName": body.FirstName,
-			"lastName":  body.LastName,
-		}
-
-		var queueData map[string]interface{}
+		// A message about a ticket is a recall, so it counts towards the
+		// ticket's recalls like POST /queue/:id/recall.
 		if body.No != nil {
-			queueData = map[string]interface{}{
-				"no":      body.No,
-				"counter": body.Counter,
+			var queue models.Queue
+			err := db.Where("person_id = ? AND no = ? AND status = ?", body.PersonID, *body.No, helpers.CALLING).
+				Order("called_at DESC").
+				First(&queue).Error
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					helpers.FormatErrorResponse(c, http.StatusConflict, "No queue with this number is being called")
+					return
+				}
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+				return
+			}
+			claims := helpers.GetClaims(c)
+			allowed, err := canOperateQueue(db, claims, queue)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+				return
 			}
+			if !allowed {
+				helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+				return
+			}
+			config, err := getConfig(db)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve config")
+				return
+			}
+			recall, status, err := recallQueue(db, hub, queue, config, actorFromClaims(claims), body.Message)
+			if err != nil {
+				helpers.FormatErrorResponse(c, status, err.Error())
+				return
+			}
+			helpers.FormatSuccessResponse(c, recall)
+			return
 		}
 
-		if err := SendPushNotification(db, hub, body.Message, userIdentifier, queueData); err != nil {
+		if err := SendPushNotification(db, body.Message, body.PersonID); err != nil {
 			log.Printf("Error sending notification: %v", err)
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, err.Error())
 			return
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+type ticketContent struct {
+	No       string
+	TopicTH  string
+	TopicEN  string
+	IssuedAt string
+	Ahead    int
+	Minutes  *int
+	URL      string
+}
+
+func ticketStatusURL(queue models.Queue) string {
+	base := os.Getenv("TICKET_STATUS_URL")
+	if base == "" {
+		base = "http://localhost:3000/ticket"
+	}
+	if queue.Code == nil {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + *queue.Code
+}
+
+func (t ticketContent) waitLines() (string, string) {
+	th := fmt.Sprintf("รอก่อนหน้า %d คิว", t.Ahead)
+	en := fmt.Sprintf("%d people ahead", t.Ahead)
+	if t.Minutes != nil {
+		th += fmt.Sprintf(" (~%d นาที)", *t.Minutes)
+		en += fmt.Sprintf(" (~%d min)", *t.Minutes)
+	}
+	return th, en
+}
+
+func renderEscPosTicket(t ticketContent, paperWidth int, codePage byte) []byte {
+	waitTH, waitEN := t.waitLines()
+	p := NewEscPos(paperWidth, codePage)
+	p.Align(escposAlignCenter).
+		Bold(true).Line(t.TopicTH).Bold(false).
+		Line(t.TopicEN).
+		Rule().
+		Size(3, 3).Bold(true).Line(t.No).Bold(false).Size(1, 1).
+		Rule().
+		Line(waitTH).
+		Line(waitEN).
+		Line(t.IssuedAt).
+		Feed(1)
+	moduleSize := byte(6)
+	if paperWidth == 58 {
+		moduleSize = 4
+	}
+	p.QRCode(t.URL, moduleSize).
+		Line("สแกนเพื่อดูสถานะคิว").
+		Line("Scan to check your queue").
+		Align(escposAlignLeft).
+		Feed(3).
+		Cut()
+	return p.Bytes()
+}
+
+var errTicketFont = errors.New("ticket font is misconfigured")
+
+// renderPDFTicket lays the ticket out on a page as wide as the paper roll.
+// Thai needs a TTF font from TICKET_FONT_PATH; without one only English is
+// printed.
+func renderPDFTicket(t ticketContent, paperWidth int) ([]byte, error) {
+	width := float64(paperWidth)
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		UnitStr: "mm",
+		Size:    fpdf.SizeType{Wd: width, Ht: width * 1.6},
+	})
+	pdf.SetMargins(3, 4, 3)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	font := "Helvetica"
+	thai := false
+	if path := os.Getenv("TICKET_FONT_PATH"); path != "" {
+		// fpdf resolves font files against its own font directory, so the
+		// file is read here to allow absolute paths.
+		fontBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errTicketFont, err)
+		}
+		// A file fpdf cannot parse leaves the font undefined, which only
+		// surfaces once it is selected.
+		pdf.AddUTF8FontFromBytes("ticket", "", fontBytes)
+		pdf.SetFont("ticket", "", 10)
+		if err := pdf.Error(); err != nil {
+			return nil, fmt.Errorf("%w: cannot load %s: %v", errTicketFont, path, err)
+		}
+		font = "ticket"
+		thai = true
+	}
+	contentWidth := width - 6
+	line := func(size float64, height float64, text string) {
+		pdf.SetFont(font, "", size)
+		pdf.CellFormat(contentWidth, height, text, "", 1, "C", false, 0, "")
+	}
+
+	waitTH, waitEN := t.waitLines()
+	if thai {
+		line(12, 6, t.TopicTH)
+	}
+	line(10, 5, t.TopicEN)
+	line(40, 18, t.No)
+	if thai {
+		line(10, 5, waitTH)
+	}
+	line(10, 5, waitEN)
+	line(9, 5, t.IssuedAt)
+
+	png, err := qrcode.Encode(t.URL, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	qrSize := contentWidth * 0.6
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+	pdf.ImageOptions("qr", (width-qrSize)/2, pdf.GetY()+2, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+	pdf.SetY(pdf.GetY() + qrSize + 3)
+	if thai {
+		line(8, 4, "สแกนเพื่อดูสถานะคิว")
+	}
+	line(8, 4, "Scan to check your queue")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetQueueTicket renders a printable ticket as raw ESC/POS bytes for thermal
+// printers or as a PDF. The paper width is 58 or 80 mm. Staff and the kiosk
+// can print any ticket, everyone else only their own.
+func GetQueueTicket(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "escpos")
+		if format != "escpos" && format != "pdf" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid format, expected escpos or pdf")
+			return
+		}
+		paperWidth := helpers.GetEnvInt("TICKET_PAPER_WIDTH", 80)
+		if value := c.Query("width"); value != "" {
+			paperWidth, _ = strconv.Atoi(value)
+		}
+		if paperWidth != 58 && paperWidth != 80 {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid width, expected 58 or 80")
+			return
+		}
+
+		var queue models.Queue
+		if err := db.Preload("Topic").First(&queue, c.Param("id")).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		if claims.Role != helpers.KIOSK && !slices.Contains(helpers.STAFF_ROLES, claims.Role) && (queue.PersonID == nil || *queue.PersonID != claims.Subject) {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "Queue does not belong to you")
+			return
+		}
+		estimate, err := EstimateQueue(db, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to estimate waiting time")
+			return
+		}
+
+		ticket := ticketContent{
+			No:       queue.No,
+			TopicTH:  queue.Topic.TopicTH,
+			TopicEN:  queue.Topic.TopicEN,
+			IssuedAt: queue.CreatedAt.In(helpers.GetBangkokTime().Location()).Format("02/01/2006 15:04"),
+			Ahead:    estimate.Position,
+			Minutes:  estimate.EstimatedMinutes,
+			URL:      ticketStatusURL(queue),
+		}
+
+		if format == "pdf" {
+			data, err := renderPDFTicket(ticket, paperWidth)
+			if errors.Is(err, errTicketFont) {
+				log.Printf("Error rendering ticket for queue %d: %v", queue.ID, err)
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Ticket font is misconfigured, check TICKET_FONT_PATH")
+				return
+			}
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to render ticket")
+				return
+			}
+			c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": "ticket-" + queue.No + ".pdf"}))
+			c.Data(http.StatusOK, "application/pdf", data)
+			return
+		}
+
+		codePage := helpers.GetEnvInt("ESCPOS_CODE_PAGE", 26)
+		data := renderEscPosTicket(ticket, paperWidth, byte(codePage))
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "ticket-" + queue.No + ".bin"}))
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	}
+}
+
+type TicketStatus struct {
+	Code            string         `json:"code"`
+	No              string         `json:"no"`
+	Status          helpers.STATUS `json:"status"`
+	Topic           models.Topic   `json:"topic"`
+	Counter         *string        `json:"counter"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	CalledAt        *time.Time     `json:"calledAt"`
+	HeldAt          *time.Time     `json:"heldAt"`
+	RequiresCheckIn bool           `json:"requiresCheckIn"`
+	CheckedInAt     *time.Time     `json:"checkedInAt"`
+	Estimate        WaitEstimate   `json:"estimate"`
+}
+
+// ticketStatus is what anyone holding the code may see, so it leaves out
+// the name and IDs of the person who reserved the ticket.
+func ticketStatus(db *gorm.DB, queue models.Queue) (TicketStatus, error) {
+	status := TicketStatus{
+		Code:            *queue.Code,
+		No:              queue.No,
+		Status:          queue.Status,
+		Topic:           queue.Topic,
+		CreatedAt:       queue.CreatedAt,
+		CalledAt:        queue.CalledAt,
+		HeldAt:          queue.HeldAt,
+		RequiresCheckIn: queue.RequiresCheckIn,
+		CheckedInAt:     queue.CheckedInAt,
+	}
+	if queue.CounterID != nil && slices.Contains(helpers.AT_COUNTER_STATUSES, queue.Status) {
+		var counter models.Counter
+		if err := db.First(&counter, *queue.CounterID).Error; err != nil {
+			return status, err
+		}
+		status.Counter = &counter.Counter
+	}
+	estimate, err := EstimateQueue(db, queue)
+	if err != nil {
+		return status, err
+	}
+	status.Estimate = estimate
+	return status, nil
+}
+
+func findTicket(c *gin.Context, db *gorm.DB) (models.Queue, bool) {
+	var queue models.Queue
+	code := strings.ToUpper(strings.TrimSpace(c.Param("code")))
+	if err := db.Preload("Topic").Where("code = ?", code).First(&queue).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Ticket not found")
+			return queue, false
+		}
+		helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ticket")
+		return queue, false
+	}
+	return queue, true
+}
+
+func GetTicketByCode(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findTicket(c, db)
+		if !ok {
+			return
+		}
+		status, err := ticketStatus(db, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ticket status")
+			return
+		}
+		helpers.FormatSuccessResponse(c, status)
+	}
+}
+
+// CheckInTicket marks a ticket's holder as physically present, called by a
+// kiosk or a staff scanner reading the ticket code. Checking in twice is not
+// an error.
+func CheckInTicket(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		queue, ok := findTicket(c, db)
+		if !ok {
+			return
+		}
+		if queue.Status != helpers.WAITING {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Only a waiting ticket can be checked in")
+			return
+		}
+
+		if queue.CheckedInAt == nil {
+			result := db.Model(&models.Queue{}).
+				Where("id = ? AND checked_in_at IS NULL", queue.ID).
+				Update("checked_in_at", time.Now())
+			if result.Error != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check in ticket")
+				return
+			}
+			if err := db.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+				return
+			}
+			if result.RowsAffected > 0 {
+				message, _ := json.Marshal(map[string]interface{}{
+					"event": "checkInQueue",
+					"data":  queue,
+				})
+				hub.broadcast <- message
+				BroadcastEstimates(db, hub, queue.TopicID)
+			}
+		}
+
+		status, err := ticketStatus(db, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to retrieve ticket status")
+			return
+		}
+		helpers.FormatSuccessResponse(c, status)
+	}
+}
@@ -14,31 +14,99 @@ import (
 func GetTopics(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var topics []struct {
-			ID      int    `json:"id"`
-			TopicTH string `json:"topicTH"`
-			TopicEN string `json:"topicEN"`
-			Code    string `json:"code"`
-			Waiting int    `json:"waiting"`
+			models.Topic
+			Waiting    int     `json:"waiting"`
+			Available  bool    `json:"available" gorm:"-"`
+			Reason     *string `json:"reason" gorm:"-"`
+			ReasonCode *string `json:"reasonCode" gorm:"-"`
 		}
 		if err := db.Table("topics").
-			Select("topics.id, topics.topic_th, topics.topic_en, topics.code, COUNT(queues.id) AS waiting").
-			Joins("LEFT JOIN queues ON queues.topic_id = topics.id AND queues.status IN (?, ?)", helpers.WAITING, helpers.IN_PROGRESS).
+			Select("topics.*, COUNT(queues.id) AS waiting").
+			Joins("LEFT JOIN queues ON queues.topic_id = topics.id AND queues.status IN ? AND queues.deleted_at IS NULL", helpers.ACTIVE_STATUSES).
 			Group("topics.id").
 			Order("topics.id ASC").
 			Scan(&topics).Error; err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch topics with waiting queues")
 			return
 		}
+
+		now := helpers.GetBangkokTime()
+		for i := range topics {
+			violation, err := checkTopicAvailability(db, topics[i].Topic, now)
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check topic availability")
+				return
+			}
+			topics[i].Available = violation == nil
+			if violation != nil {
+				topics[i].Reason = &violation.Message
+				topics[i].ReasonCode = &violation.Code
+			}
+		}
 		helpers.FormatSuccessResponse(c, topics)
 	}
 }
 
+// numberPrefix treats an empty prefix like none, so tickets use the topic code.
+func numberPrefix(prefix *string) *string {
+	if prefix == nil {
+		return nil
+	}
+	return stringPtr(*prefix)
+}
+
+func validateNumberFormat(topic models.Topic) string {
+	if topic.NumberPrefix != nil && len(*topic.NumberPrefix) > 10 {
+		return "Number prefix must be at most 10 characters"
+	}
+	if topic.NumberDigits < 1 || topic.NumberDigits > 9 {
+		return "Number digits must be between 1 and 9"
+	}
+	if !isValidResetPeriod(topic.NumberReset) {
+		return fmt.Sprintf("Invalid number reset period '%v'", topic.NumberReset)
+	}
+	if topic.NumberStart < 0 {
+		return "Number start must not be negative"
+	}
+	return ""
+}
+
+// applyTopicHours sets the availability fields that were sent. An empty
+// opening or closing time removes that limit.
+func applyTopicHours(topic *models.Topic, opensAt, closesAt *string, dailyCap *int, stopWhenPastClosing *bool) {
+	if opensAt != nil {
+		topic.OpensAt = stringPtr(*opensAt)
+	}
+	if closesAt != nil {
+		topic.ClosesAt = stringPtr(*closesAt)
+	}
+	if dailyCap != nil {
+		topic.DailyCap = *dailyCap
+	}
+	if stopWhenPastClosing != nil {
+		topic.StopWhenPastClosing = *stopWhenPastClosing
+	}
+}
+
+func isValidPriority(priority int) bool {
+	return priority >= helpers.PRIORITY_NORMAL && priority <= helpers.PRIORITY_URGENT
+}
+
 func CreateTopic(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var body struct {
-			TopicTH string `json:"topicTH"`
-			TopicEN string `json:"topicEN"`
-			Code    string `json:"code"`
+			TopicTH             string                `json:"topicTH"`
+			TopicEN             string                `json:"topicEN"`
+			Code                string                `json:"code"`
+			NumberPrefix        *string               `json:"numberPrefix"`
+			NumberDigits        *int                  `json:"numberDigits"`
+			NumberReset         *helpers.RESET_PERIOD `json:"numberReset"`
+			NumberStart         *int                  `json:"numberStart"`
+			DefaultPriority     *int                  `json:"defaultPriority"`
+			OpensAt             *string               `json:"opensAt"`
+			ClosesAt            *string               `json:"closesAt"`
+			DailyCap            *int                  `json:"dailyCap"`
+			StopWhenPastClosing *bool                 `json:"stopWhenPastClosing"`
 		}
 		if err := c.ShouldBindJSON(&body); err != nil {
 			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
@@ -55,9 +123,38 @@ func CreateTopic(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		}
 
 		topic := models.Topic{
-			TopicTH: body.TopicTH,
-			TopicEN: body.TopicEN,
-			Code:    body.Code,
+			TopicTH:      body.TopicTH,
+			TopicEN:      body.TopicEN,
+			Code:         body.Code,
+			NumberPrefix: numberPrefix(body.NumberPrefix),
+			NumberDigits: 3,
+			NumberReset:  helpers.DAILY,
+			NumberStart:  1,
+		}
+		if body.NumberDigits != nil {
+			topic.NumberDigits = *body.NumberDigits
+		}
+		if body.NumberReset != nil {
+			topic.NumberReset = *body.NumberReset
+		}
+		if body.NumberStart != nil {
+			topic.NumberStart = *body.NumberStart
+		}
+		if body.DefaultPriority != nil {
+			topic.DefaultPriority = *body.DefaultPriority
+		}
+		applyTopicHours(&topic, body.OpensAt, body.ClosesAt, body.DailyCap, body.StopWhenPastClosing)
+		if message := validateNumberFormat(topic); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
+		}
+		if !isValidPriority(topic.DefaultPriority) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid default priority")
+			return
+		}
+		if message := validateTopicHours(topic); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
 		}
 		if err := db.Create(&topic).Error; err != nil {
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to create topic")
@@ -78,9 +175,18 @@ func UpdateTopic(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		var body struct {
-			TopicTH *string `json:"topicTH"`
-			TopicEN *string `json:"topicEN"`
-			Code    *string `json:"code"`
+			TopicTH             *string               `json:"topicTH"`
+			TopicEN             *string               `json:"topicEN"`
+			Code                *string               `json:"code"`
+			NumberPrefix        *string               `json:"numberPrefix"`
+			NumberDigits        *int                  `json:"numberDigits"`
+			NumberReset         *helpers.RESET_PERIOD `json:"numberReset"`
+			NumberStart         *int                  `json:"numberStart"`
+			DefaultPriority     *int                  `json:"defaultPriority"`
+			OpensAt             *string               `json:"opensAt"`
+			ClosesAt            *string               `json:"closesAt"`
+			DailyCap            *int                  `json:"dailyCap"`
+			StopWhenPastClosing *bool                 `json:"stopWhenPastClosing"`
 		}
 		if err := c.ShouldBindJSON(&body); err != nil {
 			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
@@ -107,11 +213,53 @@ func UpdateTopic(db *gorm.DB, hub *Hub) gin.HandlerFunc {
 		if body.TopicEN != nil {
 			topic.TopicEN = *body.TopicEN
 		}
+		if body.NumberPrefix != nil {
+			topic.NumberPrefix = numberPrefix(body.NumberPrefix)
+		}
+		if body.NumberDigits != nil {
+			topic.NumberDigits = *body.NumberDigits
+		}
+		previousReset := topic.NumberReset
+		if body.NumberReset != nil {
+			topic.NumberReset = *body.NumberReset
+		}
+		if body.NumberStart != nil {
+			topic.NumberStart = *body.NumberStart
+		}
+		if body.DefaultPriority != nil {
+			topic.DefaultPriority = *body.DefaultPriority
+		}
+		applyTopicHours(&topic, body.OpensAt, body.ClosesAt, body.DailyCap, body.StopWhenPastClosing)
+		if message := validateNumberFormat(topic); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
+		}
+		if !isValidPriority(topic.DefaultPriority) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid default priority")
+			return
+		}
+		if message := validateTopicHours(topic); message != "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, message)
+			return
+		}
 
-		if err := db.Save(&topic).Error; err != nil {
+		tx := db.Begin()
+		if err := tx.Save(&topic).Error; err != nil {
+			tx.Rollback()
 			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update topic")
 			return
 		}
+		if previousReset != topic.NumberReset {
+			if err := carryOverSequence(tx, topic.ID, previousReset, topic.NumberReset, helpers.GetServiceDay()); err != nil {
+				tx.Rollback()
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to carry over queue numbering")
+				return
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
 
 		message, _ := json.Marshal(map[string]interface{}{
 			"event": "updateTopic",
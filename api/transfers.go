@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TransferDTO struct {
+	TopicID      *int   `json:"topicId"`
+	CounterID    *int   `json:"counterId"`
+	KeepPosition *bool  `json:"keepPosition"`
+	Reason       string `json:"reason"`
+}
+
+// transferQueueNo keeps the ticket's number unless the target topic already
+// issued the same number that day, in which case a new one is allocated.
+func transferQueueNo(tx *gorm.DB, queue models.Queue, topic models.Topic) (string, error) {
+	serviceDay := helpers.GetServiceDay()
+	if queue.ServiceDay != nil {
+		serviceDay = *queue.ServiceDay
+	}
+	var count int64
+	err := tx.Model(&models.Queue{}).
+		Where("id <> ? AND topic_id = ? AND service_day = ? AND no = ?", queue.ID, topic.ID, serviceDay, queue.No).
+		Count(&count).Error
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return queue.No, nil
+	}
+	return allocateQueueNo(tx, topic, serviceDay)
+}
+
+func moveWaitingQueue(tx *gorm.DB, queueID int, updates map[string]interface{}) error {
+	result := tx.Model(&models.Queue{}).Where("id = ? AND status = ?", queueID, helpers.WAITING).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrQueueChanged
+	}
+	return nil
+}
+
+// TransferQueue moves a ticket to another topic, straight to a specific
+// counter, or both. A ticket being called or served goes back to WAITING at
+// its destination and keeps its place in line unless keepPosition is false.
+func TransferQueue(db *gorm.DB, hub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var body TransferDTO
+		if err := c.ShouldBindJSON(&body); err != nil || (body.TopicID == nil && body.CounterID == nil) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "A target topic or counter is required")
+			return
+		}
+		keepPosition := body.KeepPosition == nil || *body.KeepPosition
+
+		var queue models.Queue
+		if err := db.First(&queue, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Queue not found")
+			return
+		}
+		if !slices.Contains(helpers.ACTIVE_STATUSES, queue.Status) {
+			helpers.FormatErrorResponse(c, http.StatusConflict, "Only active queues can be transferred")
+			return
+		}
+		claims := helpers.GetClaims(c)
+		allowed, err := canOperateQueue(db, claims, queue)
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			helpers.FormatErrorResponse(c, http.StatusForbidden, "You can only operate queues of your own counter")
+			return
+		}
+
+		var topic models.Topic
+		topicID := queue.TopicID
+		if body.TopicID != nil {
+			topicID = *body.TopicID
+		}
+		if err := db.First(&topic, topicID).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if body.CounterID != nil {
+			var counter models.Counter
+			if err := db.First(&counter, *body.CounterID).Error; err != nil {
+				helpers.FormatErrorResponse(c, http.StatusNotFound, "Counter not found")
+				return
+			}
+			var serves int64
+			err := db.Model(&models.CounterTopic{}).
+				Where("counter_id = ? AND topic_id = ?", counter.ID, topicID).
+				Count(&serves).Error
+			if err != nil {
+				helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check counter topics")
+				return
+			}
+			if serves == 0 {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, "Counter does not serve this topic")
+				return
+			}
+		}
+		if topicID == queue.TopicID && body.CounterID == nil && queue.Status == helpers.WAITING && queue.CounterID == nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Queue is already in this topic")
+			return
+		}
+
+		tx := db.Begin()
+		if tx.Error != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to start transaction")
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				tx.Rollback()
+			}
+		}()
+
+		no, err := transferQueueNo(tx, queue, topic)
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to allocate a queue number")
+			return
+		}
+		updates := map[string]interface{}{
+			"topic_id":   topic.ID,
+			"counter_id": nil,
+			"no":         no,
+		}
+		if body.CounterID != nil {
+			updates["counter_id"] = *body.CounterID
+		}
+		if !keepPosition {
+			updates["enqueued_at"] = time.Now()
+		}
+
+		actor := actorFromClaims(claims)
+		from := queue
+		if queue.Status == helpers.WAITING {
+			err = moveWaitingQueue(tx, queue.ID, updates)
+		} else {
+			err = TransitionQueue(tx, &queue, helpers.WAITING, actor, "Transferred", updates)
+		}
+		if err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, transitionErrorStatus(err), "Failed to transfer queue: "+err.Error())
+			return
+		}
+
+		transfer := models.QueueTransfer{
+			QueueID:       queue.ID,
+			FromTopicID:   from.TopicID,
+			ToTopicID:     topic.ID,
+			FromCounterID: from.CounterID,
+			ToCounterID:   body.CounterID,
+			FromNo:        from.No,
+			ToNo:          no,
+			KeptPosition:  keepPosition,
+			ActorUserID:   actor.UserID,
+			Reason:        stringPtr(body.Reason),
+		}
+		if err := tx.Create(&transfer).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to record transfer")
+			return
+		}
+		if err := tx.Preload("Topic").First(&queue, queue.ID).Error; err != nil {
+			tx.Rollback()
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue")
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "transferQueue",
+			"data": map[string]interface{}{
+				"queue":    queue,
+				"transfer": transfer,
+			},
+		})
+		hub.broadcast <- message
+		if from.TopicID != topic.ID {
+			BroadcastEstimates(db, hub, from.TopicID, topic.ID)
+		} else {
+			BroadcastEstimates(db, hub, topic.ID)
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]interface{}{
+			"queue":    queue,
+			"transfer": transfer,
+		})
+	}
+}
+
+func GetQueueTransfers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var transfers []models.QueueTransfer
+		if err := db.Where("queue_id = ?", id).Order("created_at ASC, id ASC").Find(&transfers).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch queue transfers")
+			return
+		}
+		helpers.FormatSuccessResponse(c, transfers)
+	}
+}
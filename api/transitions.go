@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"src/helpers"
+	"src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIllegalTransition = errors.New("illegal queue status transition")
+	ErrQueueChanged      = errors.New("queue was changed by someone else")
+)
+
+var queueTransitions = map[helpers.STATUS][]helpers.STATUS{
+	helpers.WAITING: {helpers.CALLING, helpers.SKIPPED, helpers.CANCELLED},
+	helpers.CALLING: {helpers.SERVING, helpers.COMPLETED, helpers.NO_SHOW, helpers.SKIPPED, helpers.CANCELLED, helpers.WAITING},
+	helpers.SERVING: {helpers.COMPLETED, helpers.CANCELLED, helpers.WAITING},
+	helpers.SKIPPED: {helpers.WAITING, helpers.CANCELLED},
+}
+
+type Actor struct {
+	Role     string
+	UserID   *int
+	PersonID *string
+}
+
+var SystemActor = Actor{Role: helpers.SYSTEM}
+
+func actorFromClaims(claims *helpers.Claims) Actor {
+	actor := Actor{Role: claims.Role}
+	if claims.UserID != 0 {
+		userID := claims.UserID
+		actor.UserID = &userID
+	}
+	if claims.Subject != "" {
+		personID := claims.Subject
+		actor.PersonID = &personID
+	}
+	return actor
+}
+
+func canTransition(from, to helpers.STATUS) bool {
+	return slices.Contains(queueTransitions[from], to)
+}
+
+// TransitionQueue is the only place a queue's status may change. It rejects
+// moves the lifecycle does not allow, applies any extra column updates in the
+// same statement and records who made the change. The update is guarded by
+// the status the caller loaded, so a concurrent change yields ErrQueueChanged.
+func TransitionQueue(tx *gorm.DB, queue *models.Queue, to helpers.STATUS, actor Actor, reason string, updates map[string]interface{}) error {
+	from := queue.Status
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, from, to)
+	}
+
+	values := lifecycleTimestamps(from, to, actor)
+	values["status"] = to
+	for column, value := range updates {
+		values[column] = value
+	}
+	result := tx.Model(&models.Queue{}).Where("id = ? AND status = ?", queue.ID, from).Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrQueueChanged
+	}
+
+	transition := models.QueueTransition{
+		QueueID:       queue.ID,
+		FromStatus:    from,
+		ToStatus:      to,
+		ActorRole:     actor.Role,
+		ActorUserID:   actor.UserID,
+		ActorPersonID: actor.PersonID,
+		Reason:        stringPtr(reason),
+	}
+	if err := tx.Create(&transition).Error; err != nil {
+		return err
+	}
+
+	queue.Status = to
+	return nil
+}
+
+func lifecycleTimestamps(from, to helpers.STATUS, actor Actor) map[string]interface{} {
+	now := time.Now()
+	values := map[string]interface{}{}
+	switch to {
+	case helpers.CALLING:
+		// A ticket can be called again after a transfer; its recalls start over.
+		values["called_at"] = now
+		values["recall_count"] = 0
+		values["last_recalled_at"] = nil
+		if actor.UserID != nil {
+			values["served_by_user_id"] = *actor.UserID
+		}
+	case helpers.SERVING:
+		values["service_started_at"] = now
+		if actor.UserID != nil {
+			values["served_by_user_id"] = *actor.UserID
+		}
+	case helpers.COMPLETED:
+		// Counters often finish a ticket straight from CALLING, in which case
+		// service is taken to have started when the ticket was called.
+		values["service_started_at"] = gorm.Expr("COALESCE(service_started_at, called_at)")
+		values["service_ended_at"] = now
+	case helpers.NO_SHOW, helpers.CANCELLED:
+		if from == helpers.SERVING {
+			values["service_ended_at"] = now
+		}
+	}
+	return values
+}
+
+// finishedStatus is where a ticket goes when its counter moves on. The ticket
+// being served is completed, but one still being called was never served, so
+// it is skipped and can be put back in the queue.
+func finishedStatus(status helpers.STATUS) helpers.STATUS {
+	if status == helpers.CALLING {
+		return helpers.SKIPPED
+	}
+	return helpers.COMPLETED
+}
+
+// FinishCounterQueues clears whatever is being called or served at the counter
+// when it calls the next ticket or closes, and returns the finished queues.
+func FinishCounterQueues(tx *gorm.DB, counterID int, actor Actor, reason string) ([]models.Queue, error) {
+	var queues []models.Queue
+	err := tx.Where("counter_id = ? AND status IN ?", counterID, helpers.AT_COUNTER_STATUSES).Find(&queues).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range queues {
+		if err := TransitionQueue(tx, &queues[i], finishedStatus(queues[i].Status), actor, reason, nil); err != nil {
+			return nil, err
+		}
+	}
+	return queues, nil
+}
+
+func transitionErrorStatus(err error) int {
+	if errors.Is(err, ErrIllegalTransition) || errors.Is(err, ErrQueueChanged) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"slices"
+	"src/helpers"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	legal := map[helpers.STATUS][]helpers.STATUS{
+		helpers.WAITING: {helpers.CALLING, helpers.SKIPPED, helpers.CANCELLED},
+		helpers.CALLING: {helpers.SERVING, helpers.COMPLETED, helpers.NO_SHOW, helpers.SKIPPED, helpers.CANCELLED, helpers.WAITING},
+		helpers.SERVING: {helpers.COMPLETED, helpers.CANCELLED, helpers.WAITING},
+		helpers.SKIPPED: {helpers.WAITING, helpers.CANCELLED},
+	}
+	for _, from := range helpers.ALL_STATUSES {
+		for _, to := range helpers.ALL_STATUSES {
+			want := slices.Contains(legal[from], to)
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestTerminalStatuses(t *testing.T) {
+	for _, from := range []helpers.STATUS{helpers.COMPLETED, helpers.NO_SHOW, helpers.CANCELLED} {
+		for _, to := range helpers.ALL_STATUSES {
+			if canTransition(from, to) {
+				t.Errorf("canTransition(%s, %s) = true, want a terminal status", from, to)
+			}
+		}
+	}
+}
+
+func TestFinishedStatus(t *testing.T) {
+	tests := []struct {
+		from helpers.STATUS
+		want helpers.STATUS
+	}{
+		{helpers.CALLING, helpers.SKIPPED},
+		{helpers.SERVING, helpers.COMPLETED},
+	}
+	for _, tt := range tests {
+		got := finishedStatus(tt.from)
+		if got != tt.want {
+			t.Errorf("finishedStatus(%s) = %s, want %s", tt.from, got, tt.want)
+		}
+		if !canTransition(tt.from, got) {
+			t.Errorf("finishedStatus(%s) = %s, which is not a legal transition", tt.from, got)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
 	"src/helpers"
 	"src/models"
 
@@ -11,20 +13,15 @@ import (
 
 func GetUserInfo(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		claims, err := helpers.ExtractToken(c)
-		if err != nil {
-			helpers.FormatErrorResponse(c, http.StatusUnauthorized, err.Error())
-			return
-		}
-		email, ok := (*claims)["email"].(string)
-		if !ok || email == "" {
+		claims := helpers.GetClaims(c)
+		if claims.Email == "" {
 			helpers.FormatErrorResponse(c, http.StatusUnauthorized, "Email claim is missing or invalid in token")
 			return
 		}
 		var user models.User
-		err = db.Preload("Counter", func(db *gorm.DB) *gorm.DB {
+		err := db.Preload("Counter", func(db *gorm.DB) *gorm.DB {
 			return db.Select("ID", "Counter", "TimeClosed", "Status")
-		}).Where("email = ?", email).First(&user).Error
+		}).Where("email = ?", claims.Email).First(&user).Error
 		if err != nil {
 			if err == gorm.ErrRecordNotFound {
 				helpers.FormatErrorResponse(c, http.StatusNotFound, "User not found")
@@ -37,3 +34,116 @@ func GetUserInfo(db *gorm.DB) gin.HandlerFunc {
 		helpers.FormatSuccessResponse(c, user)
 	}
 }
+
+func GetUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var users []models.User
+		err := db.Preload("Counter", func(db *gorm.DB) *gorm.DB {
+			return db.Select("ID", "Counter", "TimeClosed", "Status")
+		}).Order("id ASC").Find(&users).Error
+		if err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to fetch users")
+			return
+		}
+		helpers.FormatSuccessResponse(c, users)
+	}
+}
+
+func CreateUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := new(struct {
+			Email     string `json:"email"`
+			Role      string `json:"role"`
+			CounterID *int   `json:"counterId"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil || body.Email == "" {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if !slices.Contains(helpers.STAFF_ROLES, body.Role) {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Invalid role '%v'", body.Role))
+			return
+		}
+
+		var existingUser models.User
+		if err := db.Where("email = ?", body.Email).First(&existingUser).Error; err == nil {
+			helpers.FormatErrorResponse(c, http.StatusConflict, fmt.Sprintf("The email '%v' already exists.", body.Email))
+			return
+		} else if err != gorm.ErrRecordNotFound {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to check for existing user")
+			return
+		}
+
+		user := models.User{
+			Email:     body.Email,
+			Role:      body.Role,
+			CounterID: body.CounterID,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, user)
+	}
+}
+
+func UpdateUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		body := new(struct {
+			Role      *string `json:"role"`
+			CounterID *int    `json:"counterId"`
+		})
+		if err := c.ShouldBindJSON(body); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+
+		if body.Role != nil {
+			if !slices.Contains(helpers.STAFF_ROLES, *body.Role) {
+				helpers.FormatErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("Invalid role '%v'", *body.Role))
+				return
+			}
+			user.Role = *body.Role
+		}
+		if body.CounterID != nil {
+			user.CounterID = body.CounterID
+		}
+
+		if err := db.Save(&user).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, user)
+	}
+}
+
+func DeleteUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var user models.User
+		if err := db.First(&user, id).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+
+		if err := db.Delete(&user).Error; err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to delete user")
+			return
+		}
+		if _, err := revokeSessions(db, user.Email); err != nil {
+			helpers.FormatErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+
+		helpers.FormatSuccessResponse(c, map[string]string{"message": "User deleted successfully"})
+	}
+}
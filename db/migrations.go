@@ -2,6 +2,7 @@ package db
 
 import (
 	"log"
+	"src/helpers"
 	"src/models"
 
 	"gorm.io/gorm"
@@ -10,7 +11,20 @@ import (
 func CreateTables(db *gorm.DB) {
 	db.Exec("SET TIME ZONE 'Asia/Bangkok'")
 
+	backfillUserRoles := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "Role")
+
+	backfillServiceDays := db.Migrator().HasTable(&models.Queue{}) && !db.Migrator().HasColumn(&models.Queue{}, "ServiceDay")
+	backfillEnqueuedAt := db.Migrator().HasTable(&models.Queue{}) && !db.Migrator().HasColumn(&models.Queue{}, "EnqueuedAt")
+
+	if db.Migrator().HasTable(&models.Queue{}) && !db.Migrator().HasColumn(&models.Queue{}, "PersonID") {
+		if err := MigratePersons(db); err != nil {
+			log.Fatalf("Failed to migrate persons: %v", err)
+		}
+		log.Println("Successfully backfilled persons")
+	}
+
 	err := db.AutoMigrate(
+		&models.Person{},
 		&models.Config{},
 		&models.Subscription{},
 		&models.Counter{},
@@ -19,6 +33,16 @@ func CreateTables(db *gorm.DB) {
 		&models.CounterTopic{},
 		&models.Queue{},
 		&models.Feedback{},
+		&models.RefreshToken{},
+		&models.GuestVerification{},
+		&models.QueueSequence{},
+		&models.QueueTransition{},
+		&models.QueueTransfer{},
+		&models.AppointmentSlot{},
+		&models.Appointment{},
+		&models.QueueArchive{},
+		&models.QueueDailyStat{},
+		&models.IdempotencyKey{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to auto-migrate models: %v", err)
@@ -26,9 +50,139 @@ func CreateTables(db *gorm.DB) {
 		log.Println("Successfully migrated tables")
 	}
 
+	if err := MigrateQueueStatuses(db); err != nil {
+		log.Fatalf("Failed to migrate queue statuses: %v", err)
+	}
+
+	if backfillUserRoles {
+		// Everyone in the users table used to be an admin, so keep their access.
+		if err := db.Model(&models.User{}).Where("1 = 1").Update("role", helpers.SUPER_ADMIN).Error; err != nil {
+			log.Fatalf("Failed to backfill user roles: %v", err)
+		}
+		log.Println("Successfully backfilled user roles")
+	}
+
+	if backfillServiceDays {
+		if err := BackfillQueueSequences(db); err != nil {
+			log.Fatalf("Failed to backfill queue sequences: %v", err)
+		}
+		log.Println("Successfully backfilled queue sequences")
+	}
+
+	if backfillEnqueuedAt {
+		if err := db.Exec("UPDATE queues SET enqueued_at = created_at").Error; err != nil {
+			log.Fatalf("Failed to backfill queue positions: %v", err)
+		}
+		log.Println("Successfully backfilled queue positions")
+	}
+
+	if err := BackfillTicketCodes(db); err != nil {
+		log.Fatalf("Failed to backfill ticket codes: %v", err)
+	}
+
 	// ResetSequences(db)
 }
 
+// MigratePersons moves queues and push subscriptions from name matching to
+// person IDs: students are keyed by student ID and every guest queue gets its
+// own generated ID, since guests sharing a name are not the same person.
+func MigratePersons(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.Person{}); err != nil {
+			return err
+		}
+
+		statements := []string{
+			`ALTER TABLE queues ADD COLUMN person_id varchar(100)`,
+			`INSERT INTO people (id, kind, student_id, first_name, last_name, created_at, updated_at)
+				SELECT DISTINCT ON (student_id) student_id, 'CMU', student_id, firstname, lastname, created_at, created_at
+				FROM queues
+				WHERE student_id IS NOT NULL AND student_id <> ''
+				ORDER BY student_id, created_at DESC
+				ON CONFLICT (id) DO NOTHING`,
+			`UPDATE queues SET person_id = student_id WHERE student_id IS NOT NULL AND student_id <> ''`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		var guestQueueIDs []int
+		if err := tx.Table("queues").Where("person_id IS NULL").Pluck("id", &guestQueueIDs).Error; err != nil {
+			return err
+		}
+		for _, id := range guestQueueIDs {
+			if err := tx.Exec("UPDATE queues SET person_id = ? WHERE id = ?", helpers.NewUUID(), id).Error; err != nil {
+				return err
+			}
+		}
+
+		statements = []string{
+			`INSERT INTO people (id, kind, first_name, last_name, created_at, updated_at)
+				SELECT person_id, 'Guest', firstname, lastname, created_at, created_at
+				FROM queues
+				WHERE student_id IS NULL OR student_id = ''`,
+		}
+		if tx.Migrator().HasTable(&models.Subscription{}) && tx.Migrator().HasColumn("subscriptions", "first_name") {
+			statements = append(statements,
+				`ALTER TABLE subscriptions ADD COLUMN person_id varchar(100)`,
+				`UPDATE subscriptions SET person_id = (
+					SELECT queues.person_id FROM queues
+					WHERE queues.firstname = subscriptions.first_name AND queues.lastname = subscriptions.last_name
+					ORDER BY queues.created_at DESC LIMIT 1)`,
+				`DELETE FROM subscriptions WHERE person_id IS NULL`,
+				`DELETE FROM subscriptions a USING subscriptions b WHERE a.person_id = b.person_id AND a.ctid < b.ctid`,
+				`ALTER TABLE subscriptions DROP CONSTRAINT subscriptions_pkey`,
+				`ALTER TABLE subscriptions DROP COLUMN first_name, DROP COLUMN last_name`,
+				`ALTER TABLE subscriptions ADD PRIMARY KEY (person_id)`,
+			)
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BackfillQueueSequences stamps existing queues with their service day and
+// seeds the per-topic sequences from the highest number issued that day.
+// Tickets that were handed out twice before numbering was transactional keep
+// a NULL service day so the unique ticket index can still be built.
+func BackfillQueueSequences(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`UPDATE queues SET service_day = DATE(created_at)
+				WHERE id IN (SELECT MIN(id) FROM queues GROUP BY topic_id, DATE(created_at), no)`,
+			`INSERT INTO queue_sequences (topic_id, period_start, last_no)
+				SELECT topic_id, DATE(created_at), MAX(CAST(substring(no from '[0-9]+$') AS integer))
+				FROM queues
+				GROUP BY topic_id, DATE(created_at)
+				HAVING MAX(CAST(substring(no from '[0-9]+$') AS integer)) IS NOT NULL
+				ON CONFLICT (topic_id, period_start) DO NOTHING`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateQueueStatuses maps the old IN_PROGRESS/CALLED statuses onto the
+// queue lifecycle. It is a no-op once no old statuses remain.
+func MigrateQueueStatuses(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Queue{}).Where("status = ?", "IN_PROGRESS").Update("status", helpers.CALLING).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Queue{}).Where("status = ?", "CALLED").Update("status", helpers.COMPLETED).Error
+	})
+}
+
 func ResetSequences(db *gorm.DB) {
 	resetSequenceQuery := `
 		DO $$
@@ -57,3 +211,21 @@ func ResetSequences(db *gorm.DB) {
 		log.Println("Successfully reset sequences for all tables.")
 	}
 }
+
+// BackfillTicketCodes gives every queue issued before ticket codes existed a
+// code of its own.
+func BackfillTicketCodes(db *gorm.DB) error {
+	var queueIDs []int
+	if err := db.Model(&models.Queue{}).Where("code IS NULL").Pluck("id", &queueIDs).Error; err != nil {
+		return err
+	}
+	for _, id := range queueIDs {
+		if err := db.Model(&models.Queue{}).Where("id = ?", id).Update("code", helpers.NewTicketCode()).Error; err != nil {
+			return err
+		}
+	}
+	if len(queueIDs) > 0 {
+		log.Printf("Successfully backfilled %d ticket codes", len(queueIDs))
+	}
+	return nil
+}
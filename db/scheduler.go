@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"slices"
 	"src/api"
 	"src/helpers"
 	"src/models"
@@ -49,8 +50,8 @@ func UpdateCounterStatus(db *gorm.DB, hub *api.Hub) error {
 		return fmt.Errorf("failed to update counter status: %v", result.Error)
 	}
 
+	var updatedCounterIDs []int
 	if result.RowsAffected > 0 {
-		var updatedCounterIDs []int
 		err := tx.Model(&models.Counter{}).Where("time_closed BETWEEN ? AND ? AND status = ?", startTime, endTime, false).
 			Pluck("id", &updatedCounterIDs).Error
 		if err != nil {
@@ -65,21 +66,18 @@ func UpdateCounterStatus(db *gorm.DB, hub *api.Hub) error {
 		hub.Broadcast(message)
 
 		var affectedQueue []models.Queue
-		err = tx.Model(&models.Queue{}).
-			Where("counter_id IN (?) AND status = ?",
-				tx.Model(&models.Counter{}).Select("id").Where("status = false"),
-				helpers.IN_PROGRESS).
-			Updates(map[string]interface{}{"status": helpers.CALLED}).
-			Find(&affectedQueue).Error
-		if err != nil && err != gorm.ErrRecordNotFound {
-			tx.Rollback()
-			return fmt.Errorf("failed to update queue status: %v", err)
+		for _, counterID := range updatedCounterIDs {
+			finished, err := api.FinishCounterQueues(tx, counterID, api.SystemActor, "Counter closed")
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to update queue status: %v", err)
+			}
+			affectedQueue = append(affectedQueue, finished...)
 		}
 
 		for _, queue := range affectedQueue {
-			userIdentifier := map[string]string{
-				"firstName": queue.Firstname,
-				"lastName":  queue.Lastname,
+			if queue.PersonID == nil || queue.Status != helpers.COMPLETED {
+				continue
 			}
 			q := queue
 			go func() {
@@ -92,7 +90,7 @@ func UpdateCounterStatus(db *gorm.DB, hub *api.Hub) error {
 					log.Printf("Error creating notification message for queue %d: %v", q.ID, err)
 					return
 				}
-				err = api.SendPushNotification(db, hub, string(messageJSON), userIdentifier, nil)
+				err = api.SendPushNotification(db, string(messageJSON), *q.PersonID)
 				if err != nil {
 					log.Printf("Error sending notification for queue %d: %v", q.ID, err)
 				}
@@ -104,30 +102,210 @@ func UpdateCounterStatus(db *gorm.DB, hub *api.Hub) error {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
+	if len(updatedCounterIDs) > 0 {
+		var topicIDs []int
+		err := db.Model(&models.CounterTopic{}).Distinct("topic_id").
+			Where("counter_id IN ?", updatedCounterIDs).
+			Pluck("topic_id", &topicIDs).Error
+		if err != nil {
+			log.Printf("Error fetching topics of closed counters: %v", err)
+		} else {
+			api.BroadcastEstimates(db, hub, topicIDs...)
+		}
+	}
+
 	log.Printf("Successfully updated %d counters' status", result.RowsAffected)
 	return nil
 }
 
-func StartQueueCleanup(db *gorm.DB, interval time.Duration) {
+func StartHoldExpiry(db *gorm.DB, interval time.Duration, hub *api.Hub) {
+	go func() {
+		for {
+			err := SkipExpiredHolds(db, hub)
+			if err != nil {
+				log.Printf("Error skipping expired holds: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func SkipExpiredHolds(db *gorm.DB, hub *api.Hub) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to start transaction: %v", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	skipped, err := api.ExpireHeldQueues(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to skip expired holds: %v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	topicIDs := []int{}
+	for _, queue := range skipped {
+		message, _ := json.Marshal(map[string]interface{}{
+			"event": "updateQueueStatus",
+			"data":  queue,
+		})
+		hub.Broadcast(message)
+		if !slices.Contains(topicIDs, queue.TopicID) {
+			topicIDs = append(topicIDs, queue.TopicID)
+		}
+	}
+	if len(topicIDs) > 0 {
+		api.BroadcastEstimates(db, hub, topicIDs...)
+		log.Printf("Successfully skipped %d expired holds", len(skipped))
+	}
+	return nil
+}
+
+func StartNoShowTimer(db *gorm.DB, interval time.Duration, hub *api.Hub) {
 	go func() {
 		for {
-			err := DeleteOldQueueEntries(db)
+			err := MarkNoShows(db, hub)
 			if err != nil {
-				log.Printf("Error deleting old queue entries: %v", err)
+				log.Printf("Error marking no-shows: %v", err)
 			}
 			time.Sleep(interval)
 		}
 	}()
 }
 
-func DeleteOldQueueEntries(db *gorm.DB) error {
-	thresholdDate := helpers.GetBangkokTime().AddDate(0, 0, -30)
+func MarkNoShows(db *gorm.DB, hub *api.Hub) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to start transaction: %v", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	noShows, err := api.ExpireCalledQueues(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to mark no-shows: %v", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	for _, queue := range noShows {
+		if queue.CounterID != nil {
+			api.BroadcastNoShow(db, hub, queue, *queue.CounterID, "No-show timeout")
+		}
+	}
+	if len(noShows) > 0 {
+		log.Printf("Successfully marked %d queues as no-show", len(noShows))
+	}
+	return nil
+}
+
+func StartQueueArchiver(db *gorm.DB, interval time.Duration) {
+	go func() {
+		for {
+			err := ArchiveOldQueues(db)
+			if err != nil {
+				log.Printf("Error archiving old queues: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// ArchiveOldQueues moves whole service days older than QUEUE_RETENTION_DAYS
+// into the archive in batches, then anonymizes personal data older than
+// PII_RETENTION_DAYS.
+func ArchiveOldQueues(db *gorm.DB) error {
+	retentionDays := helpers.GetEnvInt("QUEUE_RETENTION_DAYS", 30)
+	piiRetentionDays := helpers.GetEnvInt("PII_RETENTION_DAYS", 365)
+	today := helpers.GetServiceDay()
+
+	archived := 0
+	for {
+		var moved int
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			moved, err = api.ArchiveQueues(tx, today.AddDate(0, 0, -retentionDays))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive old queues: %v", err)
+		}
+		archived += moved
+		if moved == 0 {
+			break
+		}
+	}
+
+	var anonymized int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		anonymized, err = api.AnonymizePersonalData(tx, today.AddDate(0, 0, -piiRetentionDays))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to anonymize personal data: %v", err)
+	}
+
+	log.Printf("Successfully archived %d old queues and anonymized %d archived queues", archived, anonymized)
+	return nil
+}
+
+func StartIdempotencyKeyCleanup(db *gorm.DB, interval time.Duration) {
+	go func() {
+		for {
+			err := DeleteExpiredIdempotencyKeys(db)
+			if err != nil {
+				log.Printf("Error deleting expired idempotency keys: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func DeleteExpiredIdempotencyKeys(db *gorm.DB) error {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %v", result.Error)
+	}
+
+	log.Printf("Successfully deleted %d expired idempotency keys", result.RowsAffected)
+	return nil
+}
+
+func StartRefreshTokenCleanup(db *gorm.DB, interval time.Duration) {
+	go func() {
+		for {
+			err := DeleteExpiredRefreshTokens(db)
+			if err != nil {
+				log.Printf("Error deleting expired refresh tokens: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
 
-	result := db.Where("created_at < ?", thresholdDate).Delete(&models.Queue{})
+func DeleteExpiredRefreshTokens(db *gorm.DB) error {
+	result := db.Where("expires_at < ?", time.Now()).Delete(&models.RefreshToken{})
 	if result.Error != nil {
-		return fmt.Errorf("failed to delete old queue entries: %v", result.Error)
+		return fmt.Errorf("failed to delete expired refresh tokens: %v", result.Error)
 	}
 
-	log.Printf("Successfully deleted %d old queue entries", result.RowsAffected)
+	log.Printf("Successfully deleted %d expired refresh tokens", result.RowsAffected)
 	return nil
 }
@@ -3,12 +3,60 @@ package helpers
 type STATUS string
 
 const (
-	WAITING     STATUS = "WAITING"
-	IN_PROGRESS STATUS = "IN_PROGRESS"
-	CALLED      STATUS = "CALLED"
+	WAITING   STATUS = "WAITING"
+	CALLING   STATUS = "CALLING"
+	SERVING   STATUS = "SERVING"
+	COMPLETED STATUS = "COMPLETED"
+	NO_SHOW   STATUS = "NO_SHOW"
+	SKIPPED   STATUS = "SKIPPED"
+	CANCELLED STATUS = "CANCELLED"
+)
+
+var ALL_STATUSES = []STATUS{WAITING, CALLING, SERVING, COMPLETED, NO_SHOW, SKIPPED, CANCELLED}
+
+var ACTIVE_STATUSES = []STATUS{WAITING, CALLING, SERVING}
+
+var AT_COUNTER_STATUSES = []STATUS{CALLING, SERVING}
+
+const (
+	PRIORITY_NORMAL = 0
+	PRIORITY_HIGH   = 1
+	PRIORITY_URGENT = 2
+)
+
+type APPOINTMENT_STATUS string
+
+const (
+	APPOINTMENT_BOOKED     APPOINTMENT_STATUS = "BOOKED"
+	APPOINTMENT_CHECKED_IN APPOINTMENT_STATUS = "CHECKED_IN"
+	APPOINTMENT_CANCELLED  APPOINTMENT_STATUS = "CANCELLED"
+)
+
+var OPEN_APPOINTMENT_STATUSES = []APPOINTMENT_STATUS{APPOINTMENT_BOOKED, APPOINTMENT_CHECKED_IN}
+
+type RESET_PERIOD string
+
+const (
+	DAILY  RESET_PERIOD = "DAILY"
+	WEEKLY RESET_PERIOD = "WEEKLY"
+	NEVER  RESET_PERIOD = "NEVER"
 )
 
 const (
-	ADMIN   = "Admin"
-	STUDENT = "Student"
+	SUPER_ADMIN   = "SuperAdmin"
+	COUNTER_STAFF = "CounterStaff"
+	SUPERVISOR    = "Supervisor"
+	STUDENT       = "Student"
+	GUEST         = "Guest"
+	SYSTEM        = "System"
+	KIOSK         = "Kiosk"
 )
+
+var STAFF_ROLES = []string{SUPER_ADMIN, COUNTER_STAFF, SUPERVISOR}
+
+const (
+	PERSON_CMU   = "CMU"
+	PERSON_GUEST = "Guest"
+)
+
+const CLAIMS_KEY = "claims"
@@ -1,8 +1,11 @@
 package helpers
 
 import (
+	"crypto/rand"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,10 +14,14 @@ import (
 )
 
 func FormatSuccessResponse(c *gin.Context, data interface{}) {
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, SuccessBody(data))
+}
+
+func SuccessBody(data interface{}) gin.H {
+	return gin.H{
 		"message": "success",
 		"data":    data,
-	})
+	}
 }
 
 func FormatErrorResponse(c *gin.Context, statusCode int, data interface{}) {
@@ -35,23 +42,95 @@ func FormatErrorResponse(c *gin.Context, statusCode int, data interface{}) {
 	c.JSON(statusCode, response)
 }
 
-func ExtractToken(c *gin.Context) (*jwt.MapClaims, error) {
+type Claims struct {
+	Email     string `json:"email,omitempty"`
+	StudentID string `json:"studentId,omitempty"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Faculty   string `json:"faculty,omitempty"`
+	Role      string `json:"role"`
+	UserID    int    `json:"userId,omitempty"`
+	CounterID *int   `json:"counterId,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func ExtractToken(c *gin.Context) (*Claims, error) {
 	authHeader := c.GetHeader("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
 		return nil, fmt.Errorf("Invalid authorization header")
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
+	return ParseToken(tokenString)
+}
+
+func ParseToken(tokenString string) (*Claims, error) {
+	secretKey := os.Getenv("JWT_SECRET_KEY")
+	if secretKey == "" {
+		return nil, fmt.Errorf("JWT secret key is not configured")
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secretKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
 		return nil, fmt.Errorf("Invalid token")
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, nil
+}
+
+func GetClaims(c *gin.Context) *Claims {
+	value, ok := c.Get(CLAIMS_KEY)
 	if !ok {
-		return nil, fmt.Errorf("Invalid claims in token")
+		return nil
 	}
+	claims, _ := value.(*Claims)
+	return claims
+}
 
-	return &claims, nil
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// NewTicketCode returns a random code of unambiguous characters for looking a
+// ticket up without signing in.
+func NewTicketCode() string {
+	const alphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return duration
+}
+
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 func GetBangkokTime() time.Time {
@@ -59,6 +138,11 @@ func GetBangkokTime() time.Time {
 	return time.Now().In(loc)
 }
 
+func GetServiceDay() time.Time {
+	now := GetBangkokTime()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
 func Capitalize(s string) string {
 	if len(s) > 0 {
 		return strings.ToUpper(string(s[0])) + s[1:]
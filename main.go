@@ -33,7 +33,11 @@ func main() {
 	go hub.Run()
 
 	db.StartCounterStatusUpdater(dbConn, time.Minute, hub)
-	db.StartQueueCleanup(dbConn, 24*time.Hour)
+	db.StartHoldExpiry(dbConn, time.Minute, hub)
+	db.StartNoShowTimer(dbConn, 30*time.Second, hub)
+	db.StartQueueArchiver(dbConn, 24*time.Hour)
+	db.StartRefreshTokenCleanup(dbConn, 24*time.Hour)
+	db.StartIdempotencyKeyCleanup(dbConn, time.Hour)
 
 	router := gin.Default()
 	router.Use(func(c *gin.Context) {
@@ -45,7 +49,7 @@ func main() {
 		}
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept, Authorization, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept, Authorization, X-Requested-With, Idempotency-Key, X-Kiosk-Key")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusOK)
@@ -5,19 +5,40 @@ import (
 	"time"
 
 	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 type Config struct {
-	ID          int  `json:"id" gorm:"primaryKey"`
-	LoginNotCmu bool `json:"loginNotCmu" gorm:"default:true;not null"`
+	ID                    int  `json:"id" gorm:"primaryKey"`
+	LoginNotCmu           bool `json:"loginNotCmu" gorm:"default:true;not null"`
+	HoldGraceMinutes      int  `json:"holdGraceMinutes" gorm:"default:15;not null"`
+	MaxActivePerPerson    int  `json:"maxActivePerPerson" gorm:"default:2;not null"`
+	MaxActivePerTopic     int  `json:"maxActivePerTopic" gorm:"default:1;not null"`
+	NoShowCooldownMinutes int  `json:"noShowCooldownMinutes" gorm:"default:30;not null"`
+	PriorityWeight        int  `json:"priorityWeight" gorm:"default:3;not null"`
+	MaxRecalls            int  `json:"maxRecalls" gorm:"default:3;not null"`
+	NoShowTimeoutMinutes  int  `json:"noShowTimeoutMinutes" gorm:"default:5;not null"`
+	CheckInEarlyMinutes   int  `json:"checkInEarlyMinutes" gorm:"default:15;not null"`
+	RequireCheckIn        bool `json:"requireCheckIn" gorm:"default:false;not null"`
+	DeleteUndoMinutes     int  `json:"deleteUndoMinutes" gorm:"default:10;not null"`
+}
+
+type Person struct {
+	ID        string    `json:"id" gorm:"primaryKey;size:100"`
+	Kind      string    `json:"kind" gorm:"size:20;not null"`
+	Email     *string   `json:"email" gorm:"size:100;index"`
+	StudentID *string   `json:"studentId" gorm:"size:9"`
+	FirstName string    `json:"firstName" gorm:"size:100;not null"`
+	LastName  string    `json:"lastName" gorm:"size:100;not null"`
+	CreatedAt time.Time `json:"createdAt" gorm:"default:current_timestamp"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type Subscription struct {
-	FirstName string `json:"firstName" gorm:"primaryKey;size:100"`
-	LastName  string `json:"lastName" gorm:"primaryKey;size:100"`
-	Endpoint  string `json:"endpoint" gorm:"not null"`
-	Auth      string `json:"auth" gorm:"not null"`
-	P256dh    string `json:"p256dh" gorm:"not null"`
+	PersonID string `json:"personId" gorm:"primaryKey;size:100"`
+	Endpoint string `json:"endpoint" gorm:"not null"`
+	Auth     string `json:"auth" gorm:"not null"`
+	P256dh   string `json:"p256dh" gorm:"not null"`
 }
 
 type Counter struct {
@@ -36,15 +57,25 @@ type User struct {
 	FirstNameEN *string `json:"firstNameEN" gorm:"size:100"`
 	LastNameEN  *string `json:"lastNameEN" gorm:"size:100"`
 	Email       string  `json:"email" gorm:"unique;size:100;not null"`
-	CounterID   int     `json:"counterId" gorm:"foreignKey:CounterID;constraint:OnDelete:CASCADE"`
+	Role        string  `json:"role" gorm:"size:20;default:'CounterStaff';not null"`
+	CounterID   *int    `json:"counterId" gorm:"foreignKey:CounterID;constraint:OnDelete:CASCADE"`
 	Counter     Counter `json:"counter" gorm:"foreignKey:CounterID;constraint:OnDelete:CASCADE"`
 }
 
 type Topic struct {
-	ID      int    `json:"id" gorm:"primaryKey;autoIncrement"`
-	TopicTH string `json:"topicTH" gorm:"unique;not null"`
-	TopicEN string `json:"topicEN" gorm:"unique;not null"`
-	Code    string `json:"code" gorm:"unique;not null"`
+	ID                  int                  `json:"id" gorm:"primaryKey;autoIncrement"`
+	TopicTH             string               `json:"topicTH" gorm:"unique;not null"`
+	TopicEN             string               `json:"topicEN" gorm:"unique;not null"`
+	Code                string               `json:"code" gorm:"unique;not null"`
+	NumberPrefix        *string              `json:"numberPrefix" gorm:"size:10"`
+	NumberDigits        int                  `json:"numberDigits" gorm:"default:3;not null"`
+	NumberReset         helpers.RESET_PERIOD `json:"numberReset" gorm:"size:10;default:'DAILY';not null"`
+	NumberStart         int                  `json:"numberStart" gorm:"default:1;not null"`
+	DefaultPriority     int                  `json:"defaultPriority" gorm:"default:0;not null"`
+	OpensAt             *string              `json:"opensAt" gorm:"type:time(3)"`
+	ClosesAt            *string              `json:"closesAt" gorm:"type:time(3)"`
+	DailyCap            int                  `json:"dailyCap" gorm:"default:0;not null"`
+	StopWhenPastClosing bool                 `json:"stopWhenPastClosing" gorm:"default:false;not null"`
 }
 
 type CounterTopic struct {
@@ -55,18 +86,147 @@ type CounterTopic struct {
 }
 
 type Queue struct {
-	ID        int            `json:"id" gorm:"primaryKey;autoIncrement"`
-	No        string         `json:"no" gorm:"not null"`
-	StudentID *string        `json:"studentId" gorm:"size:9"`
-	Firstname string         `json:"firstName" gorm:"not null"`
-	Lastname  string         `json:"lastName" gorm:"not null"`
-	TopicID   int            `json:"topicId" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
-	Topic     Topic          `json:"topic" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
-	Note      *string        `json:"note" gorm:"size:255"`
-	Status    helpers.STATUS `json:"status" gorm:"default:'WAITING';not null"`
-	CounterID *int           `json:"counterId" gorm:"foreignKey:CounterID;constraint:OnDelete:CASCADE"`
-	Feedback  bool           `json:"feedback" gorm:"default:false;not null"`
-	CreatedAt time.Time      `json:"createdAt" gorm:"default:current_timestamp"`
+	ID               int            `json:"id" gorm:"primaryKey;autoIncrement"`
+	No               string         `json:"no" gorm:"not null;uniqueIndex:idx_queues_ticket,priority:3"`
+	ServiceDay       *time.Time     `json:"serviceDay" gorm:"type:date;uniqueIndex:idx_queues_ticket,priority:2"`
+	PersonID         *string        `json:"personId" gorm:"size:100;index"`
+	StudentID        *string        `json:"studentId" gorm:"size:9"`
+	Firstname        string         `json:"firstName" gorm:"not null"`
+	Lastname         string         `json:"lastName" gorm:"not null"`
+	TopicID          int            `json:"topicId" gorm:"uniqueIndex:idx_queues_ticket,priority:1;foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	Topic            Topic          `json:"topic" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	Note             *string        `json:"note" gorm:"size:255"`
+	Status           helpers.STATUS `json:"status" gorm:"default:'WAITING';not null"`
+	CounterID        *int           `json:"counterId" gorm:"foreignKey:CounterID;constraint:OnDelete:CASCADE"`
+	Feedback         bool           `json:"feedback" gorm:"default:false;not null"`
+	CreatedAt        time.Time      `json:"createdAt" gorm:"default:current_timestamp"`
+	EnqueuedAt       time.Time      `json:"enqueuedAt" gorm:"default:current_timestamp;not null;index"`
+	CalledAt         *time.Time     `json:"calledAt"`
+	ServiceStartedAt *time.Time     `json:"serviceStartedAt"`
+	ServiceEndedAt   *time.Time     `json:"serviceEndedAt"`
+	ServedByUserID   *int           `json:"servedByUserId"`
+	HeldAt           *time.Time     `json:"heldAt"`
+	Priority         int            `json:"priority" gorm:"default:0;not null"`
+	RecallCount      int            `json:"recallCount" gorm:"default:0;not null"`
+	LastRecalledAt   *time.Time     `json:"lastRecalledAt"`
+	AppointmentID    *int           `json:"appointmentId" gorm:"index"`
+	Code             *string        `json:"code" gorm:"size:12;uniqueIndex"`
+	RequiresCheckIn  bool           `json:"requiresCheckIn" gorm:"default:false;not null"`
+	CheckedInAt      *time.Time     `json:"checkedInAt"`
+	DeletedAt        gorm.DeletedAt `json:"deletedAt" gorm:"index"`
+	DeletedByUserID  *int           `json:"deletedByUserId"`
+	DeleteReason     *string        `json:"deleteReason" gorm:"size:255"`
+	RestoredAt       *time.Time     `json:"restoredAt"`
+	RestoredByUserID *int           `json:"restoredByUserId"`
+}
+
+type AppointmentSlot struct {
+	ID               int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TopicID          int    `json:"topicId" gorm:"index;not null;foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	Topic            Topic  `json:"topic" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	Weekday          int    `json:"weekday" gorm:"not null"`
+	StartTime        string `json:"startTime" gorm:"type:time(3);not null"`
+	EndTime          string `json:"endTime" gorm:"type:time(3);not null"`
+	Capacity         int    `json:"capacity" gorm:"not null"`
+	BookableCapacity int    `json:"bookableCapacity" gorm:"not null"`
+}
+
+type Appointment struct {
+	ID          int                        `json:"id" gorm:"primaryKey;autoIncrement"`
+	SlotID      int                        `json:"slotId" gorm:"index:idx_appointments_slot,priority:1;not null"`
+	SlotDate    time.Time                  `json:"slotDate" gorm:"type:date;index:idx_appointments_slot,priority:2;not null"`
+	TopicID     int                        `json:"topicId" gorm:"not null;foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	Topic       Topic                      `json:"topic" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	StartTime   string                     `json:"startTime" gorm:"type:time(3);not null"`
+	EndTime     string                     `json:"endTime" gorm:"type:time(3);not null"`
+	PersonID    string                     `json:"personId" gorm:"size:100;index;not null"`
+	StudentID   *string                    `json:"studentId" gorm:"size:9"`
+	Firstname   string                     `json:"firstName" gorm:"not null"`
+	Lastname    string                     `json:"lastName" gorm:"not null"`
+	Note        *string                    `json:"note" gorm:"size:255"`
+	Status      helpers.APPOINTMENT_STATUS `json:"status" gorm:"size:20;default:'BOOKED';not null"`
+	QueueID     *int                       `json:"queueId"`
+	CreatedAt   time.Time                  `json:"createdAt" gorm:"default:current_timestamp"`
+	CheckedInAt *time.Time                 `json:"checkedInAt"`
+	CancelledAt *time.Time                 `json:"cancelledAt"`
+}
+
+type QueueTransition struct {
+	ID            int            `json:"id" gorm:"primaryKey;autoIncrement"`
+	QueueID       int            `json:"queueId" gorm:"index;not null"`
+	FromStatus    helpers.STATUS `json:"fromStatus" gorm:"not null"`
+	ToStatus      helpers.STATUS `json:"toStatus" gorm:"not null"`
+	ActorRole     string         `json:"actorRole" gorm:"size:20;not null"`
+	ActorUserID   *int           `json:"actorUserId"`
+	ActorPersonID *string        `json:"actorPersonId" gorm:"size:100"`
+	Reason        *string        `json:"reason" gorm:"size:255"`
+	CreatedAt     time.Time      `json:"createdAt" gorm:"default:current_timestamp"`
+}
+
+type QueueTransfer struct {
+	ID            int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	QueueID       int       `json:"queueId" gorm:"index;not null"`
+	FromTopicID   int       `json:"fromTopicId" gorm:"not null"`
+	ToTopicID     int       `json:"toTopicId" gorm:"not null"`
+	FromCounterID *int      `json:"fromCounterId"`
+	ToCounterID   *int      `json:"toCounterId"`
+	FromNo        string    `json:"fromNo" gorm:"not null"`
+	ToNo          string    `json:"toNo" gorm:"not null"`
+	KeptPosition  bool      `json:"keptPosition" gorm:"not null"`
+	ActorUserID   *int      `json:"actorUserId"`
+	Reason        *string   `json:"reason" gorm:"size:255"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"default:current_timestamp"`
+}
+
+// QueueArchive keeps a ticket after it leaves the queues table. It keeps the
+// queue's ID so feedback, transitions and transfers still point at it.
+type QueueArchive struct {
+	ID               int            `json:"id" gorm:"primaryKey;autoIncrement:false"`
+	No               string         `json:"no" gorm:"not null"`
+	ServiceDay       *time.Time     `json:"serviceDay" gorm:"type:date"`
+	PersonID         *string        `json:"personId" gorm:"size:100;index"`
+	StudentID        *string        `json:"studentId" gorm:"size:9"`
+	Firstname        string         `json:"firstName" gorm:"not null"`
+	Lastname         string         `json:"lastName" gorm:"not null"`
+	TopicID          int            `json:"topicId" gorm:"index;not null"`
+	Note             *string        `json:"note" gorm:"size:255"`
+	Status           helpers.STATUS `json:"status" gorm:"not null"`
+	CounterID        *int           `json:"counterId"`
+	Feedback         bool           `json:"feedback" gorm:"not null"`
+	Priority         int            `json:"priority" gorm:"not null"`
+	AppointmentID    *int           `json:"appointmentId"`
+	CreatedAt        time.Time      `json:"createdAt" gorm:"index;not null"`
+	CalledAt         *time.Time     `json:"calledAt"`
+	ServiceStartedAt *time.Time     `json:"serviceStartedAt"`
+	ServiceEndedAt   *time.Time     `json:"serviceEndedAt"`
+	ServedByUserID   *int           `json:"servedByUserId"`
+	DeletedAt        *time.Time     `json:"deletedAt"`
+	DeletedByUserID  *int           `json:"deletedByUserId"`
+	DeleteReason     *string        `json:"deleteReason" gorm:"size:255"`
+	ArchivedAt       time.Time      `json:"archivedAt" gorm:"default:current_timestamp;not null"`
+	AnonymizedAt     *time.Time     `json:"anonymizedAt"`
+}
+
+// QueueDailyStat totals the tickets of a topic per service day. Durations are
+// kept as sums and counts so any range of days can be averaged exactly.
+type QueueDailyStat struct {
+	ServiceDay     time.Time `json:"serviceDay" gorm:"primaryKey;type:date"`
+	TopicID        int       `json:"topicId" gorm:"primaryKey"`
+	Issued         int       `json:"issued" gorm:"default:0;not null"`
+	Completed      int       `json:"completed" gorm:"default:0;not null"`
+	NoShow         int       `json:"noShow" gorm:"default:0;not null"`
+	Skipped        int       `json:"skipped" gorm:"default:0;not null"`
+	Cancelled      int       `json:"cancelled" gorm:"default:0;not null"`
+	WaitSeconds    int64     `json:"waitSeconds" gorm:"default:0;not null"`
+	WaitCount      int       `json:"waitCount" gorm:"default:0;not null"`
+	ServiceSeconds int64     `json:"serviceSeconds" gorm:"default:0;not null"`
+	ServiceCount   int       `json:"serviceCount" gorm:"default:0;not null"`
+}
+
+type QueueSequence struct {
+	TopicID     int       `json:"topicId" gorm:"primaryKey"`
+	PeriodStart time.Time `json:"periodStart" gorm:"primaryKey;type:date"`
+	LastNo      int       `json:"lastNo" gorm:"not null"`
 }
 
 type Feedback struct {
@@ -75,12 +235,49 @@ type Feedback struct {
 	User      User           `json:"user" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 	TopicID   int            `json:"topicId" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
 	Topic     Topic          `json:"topic" gorm:"foreignKey:TopicID;constraint:OnDelete:CASCADE"`
+	PersonID  *string        `json:"personId" gorm:"size:100;index"`
+	QueueID   *int           `json:"queueId" gorm:"index"`
 	Rating    int            `json:"rating" gorm:"not null"`
 	Tags      pq.StringArray `json:"tags" gorm:"type:text[];default:'{}'"`
 	Feedback  *string        `json:"feedback" gorm:"size:255"`
 	CreatedAt time.Time      `json:"createdAt" gorm:"default:current_timestamp"`
 }
 
+type RefreshToken struct {
+	ID        int            `json:"id" gorm:"primaryKey;autoIncrement"`
+	TokenHash string         `json:"-" gorm:"unique;size:64;not null"`
+	Email     *string        `json:"email" gorm:"size:100;index"`
+	Claims    helpers.Claims `json:"-" gorm:"type:jsonb;serializer:json;not null"`
+	ExpiresAt time.Time      `json:"expiresAt" gorm:"not null"`
+	RevokedAt *time.Time     `json:"revokedAt"`
+	CreatedAt time.Time      `json:"createdAt" gorm:"default:current_timestamp"`
+}
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header so a retry gets the same answer.
+type IdempotencyKey struct {
+	PersonID    string    `json:"personId" gorm:"primaryKey;size:100"`
+	Key         string    `json:"key" gorm:"primaryKey;size:100"`
+	RequestHash string    `json:"-" gorm:"size:64;not null"`
+	QueueID     *int      `json:"queueId"`
+	StatusCode  int       `json:"statusCode" gorm:"default:0;not null"`
+	Response    string    `json:"-" gorm:"type:text"`
+	ExpiresAt   time.Time `json:"expiresAt" gorm:"index;not null"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"default:current_timestamp"`
+}
+
+type GuestVerification struct {
+	ID         int        `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email      string     `json:"email" gorm:"size:100;index;not null"`
+	FirstName  string     `json:"firstName" gorm:"size:100;not null"`
+	LastName   string     `json:"lastName" gorm:"size:100;not null"`
+	CodeHash   string     `json:"-" gorm:"size:64;not null"`
+	Attempts   int        `json:"attempts" gorm:"default:0;not null"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`
+	VerifiedAt *time.Time `json:"verifiedAt"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"default:current_timestamp"`
+}
+
 type UserWithoutCounter struct {
 	ID          int     `json:"id"`
 	FirstNameTH *string `json:"firstNameTH"`
@@ -88,6 +285,7 @@ type UserWithoutCounter struct {
 	FirstNameEN *string `json:"firstNameEN"`
 	LastNameEN  *string `json:"lastNameEN"`
 	Email       string  `json:"email"`
+	Role        string  `json:"role"`
 }
 
 type CounterResponse struct {